@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultAccountName is the name of the account configured by OAUTH_TOKEN or OAUTH_TOKEN_FILE
+const DefaultAccountName = "default"
+
+// Account holds the configuration of a single Nature account.
+// TokenProvider is nil if the token was set directly by an environment variable.
+type Account struct {
+	Name           string
+	OAuthToken     string
+	OAuthTokenFile string
+	TokenProvider  SecretProvider
+	TokenExpiresAt time.Time
+	APIBaseURL     string
+}
+
+// newAccount returns an account whose token was fetched by provider
+func newAccount(name string, secret *Secret, provider SecretProvider, baseURL string) *Account {
+	a := &Account{
+		Name:           name,
+		OAuthToken:     secret.Token,
+		TokenProvider:  provider,
+		TokenExpiresAt: secret.ExpiresAt,
+		APIBaseURL:     baseURL,
+	}
+	if p, ok := provider.(*FileSecretProvider); ok {
+		a.OAuthTokenFile = p.path
+	}
+	return a
+}
+
+// accountNameRe matches valid account names. Names which only differ by case or by - and _
+// are rejected as well because they share their environment variables.
+var accountNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// accountEnvPrefix returns the prefix of the environment variables of an account, e.g. ACCOUNT_MY_HOME_
+func accountEnvPrefix(name string) string {
+	return "ACCOUNT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+}
+
+// getAccounts reads the accounts listed in ACCOUNTS. Each account is configured by
+// ACCOUNT_<NAME>_OAUTH_TOKEN_FILE, ACCOUNT_<NAME>_OAUTH_TOKEN or one of the other secret providers and optionally ACCOUNT_<NAME>_API_BASE_URL.
+// If ACCOUNTS isn't set, the default account is configured by OAUTH_TOKEN_FILE or OAUTH_TOKEN.
+// No account is configured when optional is true and none of them are set.
+func getAccounts(env *environment, r Reader, baseURL string, optional bool) ([]*Account, error) {
+	names := env.getEnv("ACCOUNTS", "")
+	if names == "" {
+		if optional && !hasOAuthToken(env, "") {
+			return nil, nil
+		}
+		secret, provider, err := getOAuthToken(env, r, "")
+		if err != nil {
+			return nil, err
+		}
+		return []*Account{newAccount(DefaultAccountName, secret, provider, baseURL)}, nil
+	}
+
+	accounts := []*Account{}
+	seen := map[string]string{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("Invalid ACCOUNTS: %s. Account names must not be empty", names)
+		}
+		if !accountNameRe.MatchString(name) {
+			return nil, fmt.Errorf("Invalid ACCOUNTS: %s. Account %s must match %s", names, name, accountNameRe.String())
+		}
+		prefix := accountEnvPrefix(name)
+		if other, ok := seen[prefix]; ok {
+			if other == name {
+				return nil, fmt.Errorf("Invalid ACCOUNTS: %s. Account %s is listed twice", names, name)
+			}
+			return nil, fmt.Errorf("Invalid ACCOUNTS: %s. Accounts %s and %s would both be configured by %s* variables", names, other, name, prefix)
+		}
+		seen[prefix] = name
+
+		secret, provider, err := getOAuthToken(env, r, prefix)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, newAccount(name, secret, provider, env.getEnv(prefix+"API_BASE_URL", baseURL)))
+	}
+	return accounts, nil
+}
@@ -1,75 +1,448 @@
 package config
 
 import (
-	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/kenfdev/remo-exporter/listenaddr"
 	"github.com/kenfdev/remo-exporter/log"
 )
 
 // Config struct holds all of the runtime configuration for the application
 type Config struct {
-	APIBaseURL               string
-	OAuthToken               string
-	ListenPort               string
-	CacheInvalidationSeconds int
-	MetricsPath              string
+	APIBaseURL                         string
+	OAuthToken                         string
+	Accounts                           []*Account
+	ListenPort                         string
+	CacheInvalidationSeconds           int
+	DevicesCacheInvalidationSeconds    int
+	AppliancesCacheInvalidationSeconds int
+	AppliancesMode                     string
+	SchemaDriftDetection               bool
+	HTTPRetries                        int
+	HTTPProxyURL                       string
+	HTTPCAFile                         string
+	HTTPClientCertFile                 string
+	HTTPClientKeyFile                  string
+	HTTPTLSMinVersion                  string
+	HTTPInsecureSkipVerify             bool
+	LogLevel                           string
+	ProbeCredentials                   string
+	WebConfigFile                      string
+	ListenAddresses                    []string
+	ShutdownTimeoutSeconds             int
+	TokenFileReloadSeconds             int
+	AuthRetrySeconds                   int
+	Filters                            *Filters
+	Labels                             *StaticLabels
+	LabelMode                          string
+	Collectors                         []string
+	Namespace                          string
+	MetricNames                        string
+	MetricsPath                        string
 }
 
-func getEnv(key string, defaultValue string) string {
-	val := os.Getenv(key)
-	if len(val) == 0 {
-		val = defaultValue
+// Modes controlling how the /1/appliances endpoint is requested
+const (
+	// AppliancesModeEnabled always requests the appliances
+	AppliancesModeEnabled = "enabled"
+	// AppliancesModeDisabled never requests the appliances
+	AppliancesModeDisabled = "disabled"
+	// AppliancesModeAuto stops requesting the appliances frequently when no ECHONET Lite appliances exist
+	AppliancesModeAuto = "auto"
+)
+
+// Names of the collector groups of the exporter
+const (
+	// CollectorSensors exports the sensor values of the devices
+	CollectorSensors = "sensors"
+	// CollectorEnergy exports the values of the smart meters
+	CollectorEnergy = "energy"
+	// CollectorAppliances exports the info metrics of the appliances
+	CollectorAppliances = "appliances"
+	// CollectorRateLimit exports the rate limit of the remo API
+	CollectorRateLimit = "ratelimit"
+	// CollectorSelf exports the metrics of the exporter itself, e.g. its requests to the remo API
+	CollectorSelf = "self"
+)
+
+// CollectorNames are the names of all collector groups
+var CollectorNames = []string{CollectorSensors, CollectorEnergy, CollectorAppliances, CollectorRateLimit, CollectorSelf}
+
+// Naming schemes of the metrics
+const (
+	// MetricNamesV1 exports the metrics under their original names, e.g. remo_temperature
+	MetricNamesV1 = "v1"
+	// MetricNamesV2 exports the metrics under names following the Prometheus naming conventions,
+	// e.g. remo_temperature_celsius
+	MetricNamesV2 = "v2"
+	// MetricNamesBoth exports the metrics under both names for migrating dashboards and alerts
+	MetricNamesBoth = "both"
+)
+
+// DefaultNamespace is the default prefix of the metric names
+const DefaultNamespace = "remo"
+
+// namespaceRe matches valid prefixes of metric names
+var namespaceRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Modes controlling which labels identify the series of the devices and appliances
+const (
+	// LabelModeName labels the series by the name and the ID
+	LabelModeName = "name"
+	// LabelModeID labels the series by the ID only so renaming a device doesn't split its series.
+	// The names are exported by the info metrics.
+	LabelModeID = "id"
+	// LabelModeBoth labels the series by the name and the ID and exports the info metrics as well
+	// for migrating from LabelModeName to LabelModeID
+	LabelModeBoth = "both"
+)
+
+// collectorEnv returns the environment variable enabling a collector group, e.g. COLLECTOR_SENSORS
+func collectorEnv(name string) string {
+	return "COLLECTOR_" + strings.ToUpper(name)
+}
+
+// environment looks up the settings in the command-line flags and the environment variables.
+// Values from the configuration file are used if neither is set.
+type environment struct {
+	flags   map[string]string
+	file    map[string]string
+	filters *Filters
+	labels  *StaticLabels
+}
+
+func (e *environment) getEnv(key string, defaultValue string) string {
+	layers := e.layers()
+	if prefix, ok := oauthTokenPrefix(key); ok {
+		layers = e.tokenLayers(prefix)
 	}
-	return val
+	for _, get := range layers {
+		if val := get(key); len(val) > 0 {
+			return val
+		}
+	}
+	return defaultValue
 }
 
-func getOAuthToken(r Reader) (string, error) {
-	token := ""
-	tokenPath := getEnv("OAUTH_TOKEN_FILE", "")
-	if tokenPath == "" {
+// lookup returns the value of a setting in a single layer
+type lookup func(key string) string
+
+// layers returns the lookups of the flags, the environment variables and the configuration file
+// in this order of precedence
+func (e *environment) layers() []lookup {
+	return []lookup{
+		func(key string) string { return e.flags[key] },
+		os.Getenv,
+		func(key string) string { return e.file[key] },
+	}
+}
+
+// tokenLayers returns the highest layer setting any oauth token setting of prefix.
+// The token settings of the lower layers are hidden, so e.g. OAUTH_TOKEN overrides
+// the oauth_token_file of the configuration file.
+func (e *environment) tokenLayers(prefix string) []lookup {
+	for _, get := range e.layers() {
+		for _, suffix := range oauthTokenSettings {
+			if get(prefix+suffix) != "" {
+				return []lookup{get}
+			}
+		}
+	}
+	return nil
+}
+
+// oauthTokenEnvSuffixes are the environment variables which configure the oauth token
+var oauthTokenEnvSuffixes = []string{
+	"OAUTH_TOKEN_COMMAND",
+	"OAUTH_TOKEN_URL",
+	"OAUTH_TOKEN_ENCRYPTED_FILE",
+	"OAUTH_TOKEN_FILE",
+	"OAUTH_TOKEN",
+}
+
+// oauthTokenSettings are the oauth token environment variables including the key of the encrypted file
+var oauthTokenSettings = append([]string{"OAUTH_TOKEN_KEY_FILE"}, oauthTokenEnvSuffixes...)
+
+// oauthTokenPrefix returns the account prefix of an oauth token setting,
+// e.g. ACCOUNT_HOME_ for ACCOUNT_HOME_OAUTH_TOKEN_FILE
+func oauthTokenPrefix(key string) (string, bool) {
+	for _, suffix := range oauthTokenSettings {
+		if strings.HasSuffix(key, suffix) {
+			return strings.TrimSuffix(key, suffix), true
+		}
+	}
+	return "", false
+}
+
+// hasOAuthToken reports whether any of the oauth token environment variables with prefix is set
+func hasOAuthToken(env *environment, prefix string) bool {
+	for _, suffix := range oauthTokenEnvSuffixes {
+		if env.getEnv(prefix+suffix, "") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// getSecretProvider returns the SecretProvider configured by <prefix>OAUTH_TOKEN_COMMAND, <prefix>OAUTH_TOKEN_URL,
+// <prefix>OAUTH_TOKEN_ENCRYPTED_FILE or <prefix>OAUTH_TOKEN_FILE. It returns nil if the token is set directly.
+func getSecretProvider(env *environment, r Reader, prefix string) (SecretProvider, error) {
+	if command := env.getEnv(prefix+"OAUTH_TOKEN_COMMAND", ""); command != "" {
+		return NewExecSecretProvider(command)
+	}
+	if url := env.getEnv(prefix+"OAUTH_TOKEN_URL", ""); url != "" {
+		return NewHTTPSecretProvider(url), nil
+	}
+	if path := env.getEnv(prefix+"OAUTH_TOKEN_ENCRYPTED_FILE", ""); path != "" {
+		keyPath := env.getEnv(prefix+"OAUTH_TOKEN_KEY_FILE", "")
+		if keyPath == "" {
+			return nil, fmt.Errorf("%sOAUTH_TOKEN_KEY_FILE not set. It is required to decrypt %sOAUTH_TOKEN_ENCRYPTED_FILE", prefix, prefix)
+		}
+		return NewEncryptedFileSecretProvider(r, path, keyPath), nil
+	}
+	if path := env.getEnv(prefix+"OAUTH_TOKEN_FILE", ""); path != "" {
+		return NewFileSecretProvider(r, path), nil
+	}
+	return nil, nil
+}
+
+// getOAuthToken fetches the token from the configured SecretProvider or reads <prefix>OAUTH_TOKEN.
+// The provider is returned so the token can be fetched again.
+func getOAuthToken(env *environment, r Reader, prefix string) (*Secret, SecretProvider, error) {
+	provider, err := getSecretProvider(env, r, prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secret := &Secret{}
+	if provider == nil {
 		log.Info("No oauth token file found. Falling back to environment variable")
-		token = getEnv("OAUTH_TOKEN", "")
+		secret.Token = env.getEnv(prefix+"OAUTH_TOKEN", "")
 	} else {
-		key, err := r.ReadFile(tokenPath)
+		secret, err = provider.Fetch()
 		if err != nil {
-			return "", fmt.Errorf("Unable to load oauth token file at: %s. %s", tokenPath, err.Error())
+			return nil, nil, err
 		}
-		token = strings.TrimSpace(string(key))
 	}
 
-	if token == "" {
-		return "", errors.New("OAUTH_TOKEN not set. Be sure to set the Remo oauth token to a secret or environment variable")
+	if secret.Token == "" {
+		return nil, nil, fmt.Errorf("%sOAUTH_TOKEN not set. Be sure to set the Remo oauth token to a secret or environment variable", prefix)
 	}
 
-	return token, nil
+	return secret, provider, nil
 }
 
 // NewConfig creates a new config
 func NewConfig(r Reader) (*Config, error) {
-	token, err := getOAuthToken(r)
+	return newConfig(r, &environment{})
+}
+
+// NewConfigFromFile creates a new config from the YAML configuration file at path.
+// Environment variables override the values of the file.
+func NewConfigFromFile(r Reader, path string) (*Config, error) {
+	fc, err := readConfigFile(r, path)
+	if err != nil {
+		return nil, err
+	}
+	return newConfig(r, fc.environment())
+}
+
+func newConfig(r Reader, env *environment) (*Config, error) {
+	baseURL := env.getEnv("API_BASE_URL", "https://api.nature.global")
+	probeCredentials := env.getEnv("PROBE_CREDENTIALS", "")
+	// the accounts are optional if the exporter is used only through /probe
+	accounts, err := getAccounts(env, r, baseURL, probeCredentials != "")
+	if err != nil {
+		return nil, err
+	}
+	token := ""
+	if len(accounts) == 1 && accounts[0].Name == DefaultAccountName {
+		token = accounts[0].OAuthToken
+	}
+
+	metricsPath := env.getEnv("METRICS_PATH", "/metrics")
+	listenPort := env.getEnv("PORT", "9352")
+	cacheInvalidationSeconds, err := strconv.Atoi(env.getEnv("CACHE_INVALIDATION_SECONDS", "60"))
+	if err != nil {
+		return nil, err
+	}
+	devicesCacheInvalidationSeconds, err := strconv.Atoi(env.getEnv("DEVICES_CACHE_INVALIDATION_SECONDS", strconv.Itoa(cacheInvalidationSeconds)))
+	if err != nil {
+		return nil, err
+	}
+	appliancesCacheInvalidationSeconds, err := strconv.Atoi(env.getEnv("APPLIANCES_CACHE_INVALIDATION_SECONDS", strconv.Itoa(cacheInvalidationSeconds)))
+	if err != nil {
+		return nil, err
+	}
+	appliancesMode := env.getEnv("APPLIANCES_MODE", AppliancesModeEnabled)
+	switch appliancesMode {
+	case AppliancesModeEnabled, AppliancesModeDisabled, AppliancesModeAuto:
+	default:
+		return nil, fmt.Errorf("Invalid APPLIANCES_MODE: %s. Must be one of %s, %s or %s", appliancesMode, AppliancesModeEnabled, AppliancesModeDisabled, AppliancesModeAuto)
+	}
+
+	labelMode := env.getEnv("LABEL_MODE", LabelModeName)
+	switch labelMode {
+	case LabelModeName, LabelModeID, LabelModeBoth:
+	default:
+		return nil, fmt.Errorf("Invalid LABEL_MODE: %s. Must be one of %s, %s or %s", labelMode, LabelModeName, LabelModeID, LabelModeBoth)
+	}
+
+	schemaDriftDetection, err := strconv.ParseBool(env.getEnv("SCHEMA_DRIFT_DETECTION", "false"))
+	if err != nil {
+		return nil, err
+	}
+
+	httpRetries, err := strconv.Atoi(env.getEnv("HTTP_RETRIES", "0"))
 	if err != nil {
 		return nil, err
 	}
+	logLevel := env.getEnv("LOG_LEVEL", "info")
 
-	metricsPath := getEnv("METRICS_PATH", "/metrics")
-	baseURL := getEnv("API_BASE_URL", "https://api.nature.global")
-	listenPort := getEnv("PORT", "9352")
-	cacheInvalidationSeconds, err := strconv.Atoi(getEnv("CACHE_INVALIDATION_SECONDS", "60"))
+	httpProxyURL := env.getEnv("HTTP_PROXY_URL", "")
+	if httpProxyURL != "" {
+		if _, err := url.Parse(httpProxyURL); err != nil {
+			return nil, fmt.Errorf("Invalid HTTP_PROXY_URL: %s", err.Error())
+		}
+	}
+	httpInsecureSkipVerify, err := strconv.ParseBool(env.getEnv("HTTP_INSECURE_SKIP_VERIFY", "false"))
 	if err != nil {
 		return nil, err
 	}
 
+	tokenFileReloadSeconds, err := strconv.Atoi(env.getEnv("TOKEN_FILE_RELOAD_SECONDS", "30"))
+	if err != nil {
+		return nil, err
+	}
+
+	authRetrySeconds, err := strconv.Atoi(env.getEnv("AUTH_RETRY_SECONDS", "300"))
+	if err != nil {
+		return nil, err
+	}
+
+	metricNames := env.getEnv("METRIC_NAMES", MetricNamesV1)
+	switch metricNames {
+	case MetricNamesV1, MetricNamesV2, MetricNamesBoth:
+	default:
+		return nil, fmt.Errorf("Invalid METRIC_NAMES: %s. Must be one of %s, %s or %s", metricNames, MetricNamesV1, MetricNamesV2, MetricNamesBoth)
+	}
+	namespace := env.getEnv("NAMESPACE", DefaultNamespace)
+	if !namespaceRe.MatchString(namespace) {
+		return nil, fmt.Errorf("Invalid NAMESPACE: %s. Must match %s", namespace, namespaceRe.String())
+	}
+
+	listenAddresses := []string{}
+	for _, address := range strings.Split(env.getEnv("WEB_LISTEN_ADDRESS", ":"+listenPort), ",") {
+		address = strings.TrimSpace(address)
+		if err := listenaddr.Validate(address); err != nil {
+			return nil, fmt.Errorf("Invalid WEB_LISTEN_ADDRESS: %s", err.Error())
+		}
+		listenAddresses = append(listenAddresses, address)
+	}
+	shutdownTimeoutSeconds, err := strconv.Atoi(env.getEnv("SHUTDOWN_TIMEOUT_SECONDS", "20"))
+	if err != nil {
+		return nil, err
+	}
+
+	collectors := []string{}
+	for _, name := range CollectorNames {
+		key := collectorEnv(name)
+		enabled, err := strconv.ParseBool(env.getEnv(key, "true"))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s: %s", key, err.Error())
+		}
+		if enabled {
+			collectors = append(collectors, name)
+		}
+	}
+
 	config := &Config{
-		MetricsPath:              metricsPath,
-		APIBaseURL:               baseURL,
-		OAuthToken:               token,
-		ListenPort:               listenPort,
-		CacheInvalidationSeconds: cacheInvalidationSeconds,
+		MetricsPath:                        metricsPath,
+		APIBaseURL:                         baseURL,
+		OAuthToken:                         token,
+		Accounts:                           accounts,
+		ListenPort:                         listenPort,
+		CacheInvalidationSeconds:           cacheInvalidationSeconds,
+		DevicesCacheInvalidationSeconds:    devicesCacheInvalidationSeconds,
+		AppliancesCacheInvalidationSeconds: appliancesCacheInvalidationSeconds,
+		AppliancesMode:                     appliancesMode,
+		SchemaDriftDetection:               schemaDriftDetection,
+		HTTPRetries:                        httpRetries,
+		LogLevel:                           logLevel,
+		HTTPProxyURL:                       httpProxyURL,
+		HTTPCAFile:                         env.getEnv("HTTP_CA_FILE", ""),
+		HTTPClientCertFile:                 env.getEnv("HTTP_CLIENT_CERT_FILE", ""),
+		HTTPClientKeyFile:                  env.getEnv("HTTP_CLIENT_KEY_FILE", ""),
+		HTTPTLSMinVersion:                  env.getEnv("HTTP_TLS_MIN_VERSION", "1.2"),
+		HTTPInsecureSkipVerify:             httpInsecureSkipVerify,
+		ProbeCredentials:                   probeCredentials,
+		WebConfigFile:                      env.getEnv("WEB_CONFIG_FILE", ""),
+		ListenAddresses:                    listenAddresses,
+		ShutdownTimeoutSeconds:             shutdownTimeoutSeconds,
+		TokenFileReloadSeconds:             tokenFileReloadSeconds,
+		AuthRetrySeconds:                   authRetrySeconds,
+		Filters:                            env.filters,
+		Labels:                             env.labels,
+		LabelMode:                          labelMode,
+		Collectors:                         collectors,
+		Namespace:                          namespace,
+		MetricNames:                        metricNames,
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
 	}
 
 	return config, nil
 }
+
+// validate checks the merged settings, so the values of flags and environment variables are
+// as strict as the ones of the configuration file
+func (c *Config) validate() error {
+	if !strings.HasPrefix(c.MetricsPath, "/") {
+		return fmt.Errorf("Invalid METRICS_PATH: %s. Must start with /", c.MetricsPath)
+	}
+	if err := checkBaseURL(c.APIBaseURL); err != nil {
+		return fmt.Errorf("Invalid API_BASE_URL: %s", err.Error())
+	}
+	for _, a := range c.Accounts {
+		if err := checkBaseURL(a.APIBaseURL); err != nil {
+			return fmt.Errorf("Invalid API base URL of account %s: %s", a.Name, err.Error())
+		}
+	}
+
+	nonNegative := []struct {
+		key string
+		val int
+	}{
+		{"CACHE_INVALIDATION_SECONDS", c.CacheInvalidationSeconds},
+		{"DEVICES_CACHE_INVALIDATION_SECONDS", c.DevicesCacheInvalidationSeconds},
+		{"APPLIANCES_CACHE_INVALIDATION_SECONDS", c.AppliancesCacheInvalidationSeconds},
+		{"TOKEN_FILE_RELOAD_SECONDS", c.TokenFileReloadSeconds},
+		{"AUTH_RETRY_SECONDS", c.AuthRetrySeconds},
+		{"SHUTDOWN_TIMEOUT_SECONDS", c.ShutdownTimeoutSeconds},
+		{"HTTP_RETRIES", c.HTTPRetries},
+	}
+	for _, n := range nonNegative {
+		if n.val < 0 {
+			return fmt.Errorf("Invalid %s: %d. Must not be negative", n.key, n.val)
+		}
+	}
+	return nil
+}
+
+// checkBaseURL returns an error if baseURL isn't an absolute http or https URL
+func checkBaseURL(baseURL string) error {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q must be an absolute http or https URL", baseURL)
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/golang/mock/gomock"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 
 	. "github.com/kenfdev/remo-exporter/config"
@@ -123,10 +124,202 @@ var _ = Describe("Config", func() {
 				Expect(c.MetricsPath).To(Equal("/metrics"))
 				Expect(c.APIBaseURL).To(Equal("https://api.nature.global"))
 				Expect(c.ListenPort).To(Equal("9352"))
+				Expect(c.ListenAddresses).To(Equal([]string{":9352"}))
+				Expect(c.ShutdownTimeoutSeconds).To(Equal(20))
 				Expect(c.CacheInvalidationSeconds).To(Equal(60))
+				Expect(c.DevicesCacheInvalidationSeconds).To(Equal(60))
+				Expect(c.AppliancesCacheInvalidationSeconds).To(Equal(60))
+				Expect(c.AppliancesMode).To(Equal(AppliancesModeEnabled))
+				Expect(c.AuthRetrySeconds).To(Equal(300))
+				Expect(c.LabelMode).To(Equal(LabelModeName))
+				Expect(c.Collectors).To(Equal(CollectorNames))
+				Expect(c.Namespace).To(Equal(DefaultNamespace))
+				Expect(c.MetricNames).To(Equal(MetricNamesV1))
+				Expect(c.Accounts).To(HaveLen(1))
+				Expect(c.Accounts[0].Name).To(Equal(DefaultAccountName))
+				Expect(c.Accounts[0].OAuthToken).To(Equal(oAuthToken))
 
 			})
 		})
+		Context("Per endpoint environment variables set", func() {
+			var (
+				orgOAuthToken                         string
+				orgDevicesCacheInvalidationSeconds    string
+				orgAppliancesCacheInvalidationSeconds string
+				orgAppliancesMode                     string
+			)
+			BeforeEach(func() {
+				orgOAuthToken = os.Getenv("OAUTH_TOKEN")
+				orgDevicesCacheInvalidationSeconds = os.Getenv("DEVICES_CACHE_INVALIDATION_SECONDS")
+				orgAppliancesCacheInvalidationSeconds = os.Getenv("APPLIANCES_CACHE_INVALIDATION_SECONDS")
+				orgAppliancesMode = os.Getenv("APPLIANCES_MODE")
+
+				os.Setenv("OAUTH_TOKEN", "some_token")
+				os.Setenv("DEVICES_CACHE_INVALIDATION_SECONDS", "30")
+				os.Setenv("APPLIANCES_CACHE_INVALIDATION_SECONDS", "3600")
+			})
+			AfterEach(func() {
+				os.Setenv("OAUTH_TOKEN", orgOAuthToken)
+				os.Setenv("DEVICES_CACHE_INVALIDATION_SECONDS", orgDevicesCacheInvalidationSeconds)
+				os.Setenv("APPLIANCES_CACHE_INVALIDATION_SECONDS", orgAppliancesCacheInvalidationSeconds)
+				os.Setenv("APPLIANCES_MODE", orgAppliancesMode)
+			})
+
+			It("should override the cache invalidation seconds per endpoint", func() {
+				os.Setenv("APPLIANCES_MODE", AppliancesModeAuto)
+
+				c, err := NewConfig(mockReader)
+
+				Expect(err).Should(BeNil())
+				Expect(c.CacheInvalidationSeconds).To(Equal(60))
+				Expect(c.DevicesCacheInvalidationSeconds).To(Equal(30))
+				Expect(c.AppliancesCacheInvalidationSeconds).To(Equal(3600))
+				Expect(c.AppliancesMode).To(Equal(AppliancesModeAuto))
+			})
+			It("should fail with an invalid appliances mode", func() {
+				os.Setenv("APPLIANCES_MODE", "sometimes")
+
+				c, err := NewConfig(mockReader)
+
+				Expect(c).To(BeNil())
+				Expect(err).NotTo(BeNil())
+			})
+		})
+		Context("Invalid environment variables set", func() {
+			var (
+				orgOAuthToken string
+			)
+			BeforeEach(func() {
+				orgOAuthToken = os.Getenv("OAUTH_TOKEN")
+				os.Setenv("OAUTH_TOKEN", "some_token")
+			})
+			AfterEach(func() {
+				os.Setenv("OAUTH_TOKEN", orgOAuthToken)
+			})
+			DescribeTable("should be rejected like the values of the configuration file",
+				func(key string, value string, expected string) {
+					org := os.Getenv(key)
+					os.Setenv(key, value)
+					defer os.Setenv(key, org)
+
+					c, err := NewConfig(mockReader)
+
+					Expect(c).To(BeNil())
+					Expect(err).NotTo(BeNil())
+					Expect(err.Error()).To(ContainSubstring(expected))
+				},
+				Entry("negative cache period", "CACHE_INVALIDATION_SECONDS", "-5", "Invalid CACHE_INVALIDATION_SECONDS: -5. Must not be negative"),
+				Entry("negative retries", "HTTP_RETRIES", "-1", "Invalid HTTP_RETRIES: -1. Must not be negative"),
+				Entry("metrics path without a leading slash", "METRICS_PATH", "metrics", "Invalid METRICS_PATH: metrics. Must start with /"),
+				Entry("relative base url", "API_BASE_URL", "api.nature.global", "Invalid API_BASE_URL"),
+				Entry("base url with another scheme", "API_BASE_URL", "ftp://api.nature.global", "Invalid API_BASE_URL"),
+			)
+		})
+		Context("ACCOUNTS set", func() {
+			var (
+				orgAccounts           string
+				orgHomeOAuthTokenFile string
+				orgOfficeOAuthToken   string
+				orgOfficeAPIBaseURL   string
+				orgMyOfficeOAuthToken string
+				orgHomeOAuthTokenCmd  string
+			)
+			BeforeEach(func() {
+				orgAccounts = os.Getenv("ACCOUNTS")
+				orgHomeOAuthTokenFile = os.Getenv("ACCOUNT_HOME_OAUTH_TOKEN_FILE")
+				orgOfficeOAuthToken = os.Getenv("ACCOUNT_OFFICE_OAUTH_TOKEN")
+				orgOfficeAPIBaseURL = os.Getenv("ACCOUNT_OFFICE_API_BASE_URL")
+				orgMyOfficeOAuthToken = os.Getenv("ACCOUNT_MY_OFFICE_OAUTH_TOKEN")
+				orgHomeOAuthTokenCmd = os.Getenv("ACCOUNT_HOME_OAUTH_TOKEN_COMMAND")
+			})
+			AfterEach(func() {
+				os.Setenv("ACCOUNTS", orgAccounts)
+				os.Setenv("ACCOUNT_HOME_OAUTH_TOKEN_FILE", orgHomeOAuthTokenFile)
+				os.Setenv("ACCOUNT_OFFICE_OAUTH_TOKEN", orgOfficeOAuthToken)
+				os.Setenv("ACCOUNT_OFFICE_API_BASE_URL", orgOfficeAPIBaseURL)
+				os.Setenv("ACCOUNT_MY_OFFICE_OAUTH_TOKEN", orgMyOfficeOAuthToken)
+				os.Setenv("ACCOUNT_HOME_OAUTH_TOKEN_COMMAND", orgHomeOAuthTokenCmd)
+			})
+			It("should configure each account", func() {
+				os.Setenv("ACCOUNTS", "home, office")
+				os.Setenv("ACCOUNT_HOME_OAUTH_TOKEN_FILE", "path/to/home")
+				os.Setenv("ACCOUNT_OFFICE_OAUTH_TOKEN", "office_token")
+				os.Setenv("ACCOUNT_OFFICE_API_BASE_URL", "https://path.to/office")
+
+				mockReader.EXPECT().ReadFile("path/to/home").Return([]byte("home_token\n"), nil)
+
+				c, err := NewConfig(mockReader)
+
+				Expect(err).Should(BeNil())
+				Expect(c.Accounts).To(HaveLen(2))
+				Expect(c.Accounts[0].Name).To(Equal("home"))
+				Expect(c.Accounts[0].OAuthToken).To(Equal("home_token"))
+				Expect(c.Accounts[0].OAuthTokenFile).To(Equal("path/to/home"))
+				Expect(c.Accounts[0].TokenProvider).To(BeAssignableToTypeOf(&FileSecretProvider{}))
+				Expect(c.Accounts[0].APIBaseURL).To(Equal("https://api.nature.global"))
+				Expect(*c.Accounts[1]).To(Equal(Account{
+					Name:       "office",
+					OAuthToken: "office_token",
+					APIBaseURL: "https://path.to/office",
+				}))
+			})
+			It("should normalize the account names for the environment variables", func() {
+				os.Setenv("ACCOUNTS", "my-office")
+				os.Setenv("ACCOUNT_MY_OFFICE_OAUTH_TOKEN", "office_token")
+
+				c, err := NewConfig(mockReader)
+
+				Expect(err).Should(BeNil())
+				Expect(c.Accounts[0].Name).To(Equal("my-office"))
+				Expect(c.Accounts[0].OAuthToken).To(Equal("office_token"))
+			})
+			It("should fetch the token of an account from a command", func() {
+				os.Setenv("ACCOUNTS", "home")
+				os.Setenv("ACCOUNT_HOME_OAUTH_TOKEN_COMMAND", "echo home_token")
+
+				c, err := NewConfig(mockReader)
+
+				Expect(err).Should(BeNil())
+				Expect(c.Accounts[0].OAuthToken).To(Equal("home_token"))
+				Expect(c.Accounts[0].OAuthTokenFile).To(BeEmpty())
+				Expect(c.Accounts[0].TokenProvider).To(BeAssignableToTypeOf(&ExecSecretProvider{}))
+			})
+			It("should fail if the token of an account isn't set", func() {
+				os.Setenv("ACCOUNTS", "home,office")
+				os.Setenv("ACCOUNT_OFFICE_OAUTH_TOKEN", "office_token")
+
+				c, err := NewConfig(mockReader)
+
+				Expect(c).To(BeNil())
+				Expect(err.Error()).To(ContainSubstring("ACCOUNT_HOME_OAUTH_TOKEN"))
+			})
+			It("should fail if an account is listed twice", func() {
+				os.Setenv("ACCOUNTS", "office,office")
+				os.Setenv("ACCOUNT_OFFICE_OAUTH_TOKEN", "office_token")
+
+				c, err := NewConfig(mockReader)
+
+				Expect(c).To(BeNil())
+				Expect(err).NotTo(BeNil())
+			})
+			It("should fail if the names of two accounts share their variables", func() {
+				os.Setenv("ACCOUNTS", "my-office,my_office")
+				os.Setenv("ACCOUNT_MY_OFFICE_OAUTH_TOKEN", "office_token")
+
+				c, err := NewConfig(mockReader)
+
+				Expect(c).To(BeNil())
+				Expect(err.Error()).To(ContainSubstring("ACCOUNT_MY_OFFICE_*"))
+			})
+			It("should fail if the name of an account has invalid characters", func() {
+				os.Setenv("ACCOUNTS", "my.office")
+
+				c, err := NewConfig(mockReader)
+
+				Expect(c).To(BeNil())
+				Expect(err.Error()).To(ContainSubstring("Account my.office must match"))
+			})
+		})
 		Context("Environment variables set", func() {
 			const (
 				apiBaseURL               string = "https://path.to/somewhere"
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ErrUnknownTarget is returned when no token is found for a probe target
+var ErrUnknownTarget = errors.New("unknown target")
+
+var targetNameRe = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+// ProbeCredentials looks up the oauth tokens of the probe targets.
+// The path is either a directory containing a token file named after each target,
+// or a file with a "<target>=<token>" line per target.
+// The credentials are read on every lookup so changes are applied without a restart.
+type ProbeCredentials struct {
+	reader Reader
+	path   string
+	isDir  bool
+}
+
+// NewProbeCredentials returns the credentials stored at path
+func NewProbeCredentials(r Reader, path string) (*ProbeCredentials, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load probe credentials at: %s. %s", path, err.Error())
+	}
+	return &ProbeCredentials{
+		reader: r,
+		path:   path,
+		isDir:  info.IsDir(),
+	}, nil
+}
+
+// Lookup returns the oauth token of the target
+func (c *ProbeCredentials) Lookup(target string) (string, error) {
+	if !targetNameRe.MatchString(target) {
+		return "", fmt.Errorf("invalid target name: %q", target)
+	}
+
+	if c.isDir {
+		data, err := c.reader.ReadFile(filepath.Join(c.path, target))
+		if errors.Is(err, os.ErrNotExist) {
+			return "", ErrUnknownTarget
+		}
+		if err != nil {
+			return "", err
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", ErrUnknownTarget
+		}
+		return token, nil
+	}
+
+	data, err := c.reader.ReadFile(c.path)
+	if err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, token, ok := strings.Cut(line, "=")
+		if ok && strings.TrimSpace(name) == target && strings.TrimSpace(token) != "" {
+			return strings.TrimSpace(token), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", ErrUnknownTarget
+}
@@ -0,0 +1,72 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kenfdev/remo-exporter/config"
+)
+
+var _ = Describe("ProbeCredentials", func() {
+	var (
+		dir string
+	)
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "credentials")
+		Expect(err).Should(BeNil())
+	})
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Context("directory", func() {
+		It("should read the token from the file named after the target", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "home"), []byte("home_token\n"), 0600)).Should(Succeed())
+
+			c, err := NewProbeCredentials(NewFileReader(), dir)
+			Expect(err).Should(BeNil())
+
+			token, err := c.Lookup("home")
+			Expect(err).Should(BeNil())
+			Expect(token).To(Equal("home_token"))
+
+			_, err = c.Lookup("office")
+			Expect(err).To(Equal(ErrUnknownTarget))
+		})
+		It("should reject target names outside of the directory", func() {
+			c, err := NewProbeCredentials(NewFileReader(), dir)
+			Expect(err).Should(BeNil())
+
+			_, err = c.Lookup("../secret")
+			Expect(err).NotTo(BeNil())
+			Expect(err).NotTo(Equal(ErrUnknownTarget))
+		})
+	})
+
+	Context("file", func() {
+		It("should read the token from the line of the target", func() {
+			path := filepath.Join(dir, "credentials")
+			Expect(os.WriteFile(path, []byte("# comment\nhome=home_token\noffice = office_token\n"), 0600)).Should(Succeed())
+
+			c, err := NewProbeCredentials(NewFileReader(), path)
+			Expect(err).Should(BeNil())
+
+			token, err := c.Lookup("office")
+			Expect(err).Should(BeNil())
+			Expect(token).To(Equal("office_token"))
+
+			_, err = c.Lookup("shop")
+			Expect(err).To(Equal(ErrUnknownTarget))
+		})
+	})
+
+	It("should fail if the path doesn't exist", func() {
+		c, err := NewProbeCredentials(NewFileReader(), filepath.Join(dir, "missing"))
+		Expect(c).To(BeNil())
+		Expect(err).NotTo(BeNil())
+	})
+})
@@ -0,0 +1,489 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kenfdev/remo-exporter/listenaddr"
+)
+
+// fileConfig is the schema of the YAML configuration file.
+// The keys are the lower cased environment variables, see the README.
+type fileConfig struct {
+	fileToken `yaml:",inline"`
+
+	APIBaseURL                         string         `yaml:"api_base_url"`
+	MetricsPath                        string         `yaml:"metrics_path"`
+	Port                               *int           `yaml:"port"`
+	LogLevel                           string         `yaml:"log_level"`
+	CacheInvalidationSeconds           *int           `yaml:"cache_invalidation_seconds"`
+	DevicesCacheInvalidationSeconds    *int           `yaml:"devices_cache_invalidation_seconds"`
+	AppliancesCacheInvalidationSeconds *int           `yaml:"appliances_cache_invalidation_seconds"`
+	AppliancesMode                     string         `yaml:"appliances_mode"`
+	LabelMode                          string         `yaml:"label_mode"`
+	Namespace                          string         `yaml:"namespace"`
+	MetricNames                        string         `yaml:"metric_names"`
+	SchemaDriftDetection               *bool          `yaml:"schema_drift_detection"`
+	TokenFileReloadSeconds             *int           `yaml:"token_file_reload_seconds"`
+	AuthRetrySeconds                   *int           `yaml:"auth_retry_seconds"`
+	ProbeCredentials                   string         `yaml:"probe_credentials"`
+	WebConfigFile                      string         `yaml:"web_config_file"`
+	WebListenAddress                   []string       `yaml:"web_listen_address"`
+	ShutdownTimeoutSeconds             *int           `yaml:"shutdown_timeout_seconds"`
+	HTTP                               fileHTTPConfig `yaml:"http"`
+	Accounts                           []fileAccount  `yaml:"accounts"`
+	Filters                            fileFilters    `yaml:"filters"`
+	Labels                             fileLabels     `yaml:"labels"`
+	Collectors                         fileCollectors `yaml:"collectors"`
+}
+
+// fileToken configures the oauth token of an account
+type fileToken struct {
+	OAuthToken              string `yaml:"oauth_token"`
+	OAuthTokenFile          string `yaml:"oauth_token_file"`
+	OAuthTokenCommand       string `yaml:"oauth_token_command"`
+	OAuthTokenURL           string `yaml:"oauth_token_url"`
+	OAuthTokenEncryptedFile string `yaml:"oauth_token_encrypted_file"`
+	OAuthTokenKeyFile       string `yaml:"oauth_token_key_file"`
+}
+
+type fileHTTPConfig struct {
+	Retries            *int   `yaml:"retries"`
+	ProxyURL           string `yaml:"proxy_url"`
+	CAFile             string `yaml:"ca_file"`
+	ClientCertFile     string `yaml:"client_cert_file"`
+	ClientKeyFile      string `yaml:"client_key_file"`
+	TLSMinVersion      string `yaml:"tls_min_version"`
+	InsecureSkipVerify *bool  `yaml:"insecure_skip_verify"`
+}
+
+type fileFilters struct {
+	Include []fileFilterRule `yaml:"include"`
+	Exclude []fileFilterRule `yaml:"exclude"`
+}
+
+type fileFilterRule struct {
+	DeviceID          string `yaml:"device_id"`
+	DeviceName        string `yaml:"device_name"`
+	ApplianceType     string `yaml:"appliance_type"`
+	ApplianceNickname string `yaml:"appliance_nickname"`
+}
+
+// fileLabels are the static labels keyed by the device or appliance ID
+type fileLabels struct {
+	Devices    map[string]map[string]string `yaml:"devices"`
+	Appliances map[string]map[string]string `yaml:"appliances"`
+}
+
+// fileCollectors enables or disables the collector groups
+type fileCollectors struct {
+	Sensors    *bool `yaml:"sensors"`
+	Energy     *bool `yaml:"energy"`
+	Appliances *bool `yaml:"appliances"`
+	RateLimit  *bool `yaml:"ratelimit"`
+	Self       *bool `yaml:"self"`
+}
+
+type fileAccount struct {
+	fileToken `yaml:",inline"`
+
+	Name       string `yaml:"name"`
+	APIBaseURL string `yaml:"api_base_url"`
+}
+
+var (
+	labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// reservedLabels are the labels of the metrics of the devices and appliances
+	reservedLabels = map[string]bool{"account": true, "name": true, "id": true, "sensor": true}
+)
+
+// typeErrorRe matches the go type yaml.v3 appends to its errors
+var typeErrorRe = regexp.MustCompile(`^line (\d+): (.*?)(?: in type \S+)?$`)
+
+// readConfigFile reads the configuration file at path
+func readConfigFile(r Reader, path string) (*fileConfig, error) {
+	data, err := r.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load configuration file at: %s. %s", path, err.Error())
+	}
+	return loadConfigFile(path, data)
+}
+
+// loadConfigFile strictly decodes and validates the configuration file
+func loadConfigFile(path string, data []byte) (*fileConfig, error) {
+	root := &yaml.Node{}
+	if err := yaml.Unmarshal(data, root); err != nil {
+		return nil, fmt.Errorf("Invalid configuration file %s: %s", path, err.Error())
+	}
+
+	c := &fileConfig{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("Invalid configuration file %s: %s", path, err.Error())
+		}
+		msgs := []string{}
+		for _, e := range typeErr.Errors {
+			if m := typeErrorRe.FindStringSubmatch(e); m != nil {
+				msgs = append(msgs, fmt.Sprintf("%s:%s: %s", path, m[1], m[2]))
+			} else {
+				msgs = append(msgs, path+": "+e)
+			}
+		}
+		return nil, fmt.Errorf("Invalid configuration file: %s", strings.Join(msgs, "; "))
+	}
+
+	v := &fileValidator{path: path, root: root}
+	v.validate(c)
+	if len(v.errs) > 0 {
+		return nil, fmt.Errorf("Invalid configuration file: %s", strings.Join(v.errs, "; "))
+	}
+
+	return c, nil
+}
+
+// fileValidator collects the errors of a configuration file with the line of the offending key
+type fileValidator struct {
+	path string
+	root *yaml.Node
+	errs []string
+}
+
+func (v *fileValidator) errorf(keyPath []interface{}, format string, args ...interface{}) {
+	line := nodeLine(v.root, keyPath...)
+	v.errs = append(v.errs, fmt.Sprintf("%s:%d: %s", v.path, line, fmt.Sprintf(format, args...)))
+}
+
+func (v *fileValidator) validate(c *fileConfig) {
+	if c.MetricsPath != "" && !strings.HasPrefix(c.MetricsPath, "/") {
+		v.errorf([]interface{}{"metrics_path"}, "metrics_path %q must start with /", c.MetricsPath)
+	}
+	v.validateBaseURL([]interface{}{"api_base_url"}, c.APIBaseURL)
+	if c.HTTP.ProxyURL != "" {
+		if _, err := url.Parse(c.HTTP.ProxyURL); err != nil {
+			v.errorf([]interface{}{"http", "proxy_url"}, "invalid proxy_url: %s", err.Error())
+		}
+	}
+
+	nonNegative := []struct {
+		key string
+		val *int
+	}{
+		{"cache_invalidation_seconds", c.CacheInvalidationSeconds},
+		{"devices_cache_invalidation_seconds", c.DevicesCacheInvalidationSeconds},
+		{"appliances_cache_invalidation_seconds", c.AppliancesCacheInvalidationSeconds},
+		{"token_file_reload_seconds", c.TokenFileReloadSeconds},
+		{"auth_retry_seconds", c.AuthRetrySeconds},
+		{"shutdown_timeout_seconds", c.ShutdownTimeoutSeconds},
+	}
+	for _, n := range nonNegative {
+		if n.val != nil && *n.val < 0 {
+			v.errorf([]interface{}{n.key}, "%s must not be negative", n.key)
+		}
+	}
+	if c.HTTP.Retries != nil && *c.HTTP.Retries < 0 {
+		v.errorf([]interface{}{"http", "retries"}, "retries must not be negative")
+	}
+	if c.Port != nil && (*c.Port <= 0 || *c.Port > 65535) {
+		v.errorf([]interface{}{"port"}, "port %d is out of range", *c.Port)
+	}
+	for i, address := range c.WebListenAddress {
+		if err := listenaddr.Validate(address); err != nil {
+			v.errorf([]interface{}{"web_listen_address", i}, "%s", err.Error())
+		}
+	}
+
+	switch c.AppliancesMode {
+	case "", AppliancesModeEnabled, AppliancesModeDisabled, AppliancesModeAuto:
+	default:
+		v.errorf([]interface{}{"appliances_mode"}, "appliances_mode must be one of %s, %s or %s", AppliancesModeEnabled, AppliancesModeDisabled, AppliancesModeAuto)
+	}
+
+	if c.Namespace != "" && !namespaceRe.MatchString(c.Namespace) {
+		v.errorf([]interface{}{"namespace"}, "invalid namespace %q", c.Namespace)
+	}
+	switch c.MetricNames {
+	case "", MetricNamesV1, MetricNamesV2, MetricNamesBoth:
+	default:
+		v.errorf([]interface{}{"metric_names"}, "metric_names must be one of %s, %s or %s", MetricNamesV1, MetricNamesV2, MetricNamesBoth)
+	}
+
+	switch c.LabelMode {
+	case "", LabelModeName, LabelModeID, LabelModeBoth:
+	default:
+		v.errorf([]interface{}{"label_mode"}, "label_mode must be one of %s, %s or %s", LabelModeName, LabelModeID, LabelModeBoth)
+	}
+
+	for _, list := range []struct {
+		key   string
+		rules []fileFilterRule
+	}{
+		{"include", c.Filters.Include},
+		{"exclude", c.Filters.Exclude},
+	} {
+		for i, rule := range list.rules {
+			v.validateFilterRule([]interface{}{"filters", list.key, i}, rule)
+		}
+	}
+
+	v.validateLabels(c.Labels)
+
+	seen := map[string]string{}
+	for i, a := range c.Accounts {
+		if a.Name == "" {
+			v.errorf([]interface{}{"accounts", i}, "the name of the account is missing")
+			continue
+		}
+		if !accountNameRe.MatchString(a.Name) {
+			v.errorf([]interface{}{"accounts", i, "name"}, "account name %q must match %s", a.Name, accountNameRe.String())
+			continue
+		}
+		prefix := accountEnvPrefix(a.Name)
+		if other, ok := seen[prefix]; ok && other == a.Name {
+			v.errorf([]interface{}{"accounts", i, "name"}, "account %s is listed twice", a.Name)
+		} else if ok {
+			v.errorf([]interface{}{"accounts", i, "name"}, "accounts %s and %s would both be configured by %s* variables", other, a.Name, prefix)
+		}
+		seen[prefix] = a.Name
+		v.validateBaseURL([]interface{}{"accounts", i, "api_base_url"}, a.APIBaseURL)
+	}
+}
+
+func (v *fileValidator) validateFilterRule(keyPath []interface{}, rule fileFilterRule) {
+	set := 0
+	for _, val := range []string{rule.DeviceID, rule.DeviceName, rule.ApplianceType, rule.ApplianceNickname} {
+		if val != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		v.errorf(keyPath, "a filter rule must have exactly one of device_id, device_name, appliance_type or appliance_nickname")
+		return
+	}
+	if _, err := regexp.Compile(rule.DeviceName); err != nil {
+		v.errorf(append(keyPath, "device_name"), "invalid device_name: %s", err.Error())
+	}
+	if _, err := regexp.Compile(rule.ApplianceNickname); err != nil {
+		v.errorf(append(keyPath, "appliance_nickname"), "invalid appliance_nickname: %s", err.Error())
+	}
+}
+
+// validateLabels checks that the label names are valid and every entity has the same keys
+func (v *fileValidator) validateLabels(l fileLabels) {
+	var keys []string
+	for _, kind := range []struct {
+		key      string
+		entities map[string]map[string]string
+	}{
+		{"devices", l.Devices},
+		{"appliances", l.Appliances},
+	} {
+		for _, id := range sortedKeys(kind.entities) {
+			labels := kind.entities[id]
+			entityKeys := sortedKeys(labels)
+			for _, key := range entityKeys {
+				if !labelNameRe.MatchString(key) || strings.HasPrefix(key, "__") {
+					v.errorf([]interface{}{"labels", kind.key, id, key}, "invalid label name %q", key)
+				} else if reservedLabels[key] {
+					v.errorf([]interface{}{"labels", kind.key, id, key}, "label %q is reserved by the exporter", key)
+				}
+			}
+			if keys == nil {
+				keys = entityKeys
+			} else if strings.Join(keys, ",") != strings.Join(entityKeys, ",") {
+				v.errorf([]interface{}{"labels", kind.key, id}, "the labels of %s must have the same keys as the other entities: %s", id, strings.Join(keys, ", "))
+			}
+		}
+	}
+}
+
+func (v *fileValidator) validateBaseURL(keyPath []interface{}, baseURL string) {
+	if baseURL == "" {
+		return
+	}
+	if err := checkBaseURL(baseURL); err != nil {
+		v.errorf(keyPath, "invalid api_base_url: %s", err.Error())
+	}
+}
+
+// nodeLine returns the line of the value at keyPath. Map keys are strings and sequence indices ints.
+// The line of the deepest node found is returned if the path doesn't exist.
+func nodeLine(n *yaml.Node, keyPath ...interface{}) int {
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	for _, k := range keyPath {
+		var next *yaml.Node
+		switch key := k.(type) {
+		case string:
+			if n.Kind != yaml.MappingNode {
+				break
+			}
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == key {
+					next = n.Content[i+1]
+					break
+				}
+			}
+		case int:
+			if n.Kind == yaml.SequenceNode && key < len(n.Content) {
+				next = n.Content[key]
+			}
+		}
+		if next == nil {
+			break
+		}
+		n = next
+	}
+	return n.Line
+}
+
+// environment returns the environment looking up the settings of the file
+func (c *fileConfig) environment() *environment {
+	return &environment{
+		file:    c.values(),
+		filters: c.Filters.filters(),
+		labels:  c.Labels.staticLabels(),
+	}
+}
+
+// staticLabels returns the labels of the file. They were validated before.
+func (l *fileLabels) staticLabels() *StaticLabels {
+	res := &StaticLabels{
+		Devices:    l.Devices,
+		Appliances: l.Appliances,
+	}
+	for _, labels := range l.Devices {
+		res.Keys = sortedKeys(labels)
+		break
+	}
+	for _, labels := range l.Appliances {
+		res.Keys = sortedKeys(labels)
+		break
+	}
+	if len(res.Keys) == 0 {
+		return nil
+	}
+	return res
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// filters compiles the filter rules. The rules were validated before.
+func (f *fileFilters) filters() *Filters {
+	if len(f.Include) == 0 && len(f.Exclude) == 0 {
+		return nil
+	}
+	compile := func(rules []fileFilterRule) []*FilterRule {
+		res := []*FilterRule{}
+		for _, r := range rules {
+			rule := &FilterRule{
+				DeviceID:      r.DeviceID,
+				ApplianceType: r.ApplianceType,
+			}
+			if r.DeviceName != "" {
+				rule.DeviceName = regexp.MustCompile(r.DeviceName)
+			}
+			if r.ApplianceNickname != "" {
+				rule.ApplianceNickname = regexp.MustCompile(r.ApplianceNickname)
+			}
+			res = append(res, rule)
+		}
+		return res
+	}
+	return &Filters{
+		Include: compile(f.Include),
+		Exclude: compile(f.Exclude),
+	}
+}
+
+// values returns the settings of the file keyed by their environment variable
+func (c *fileConfig) values() map[string]string {
+	values := map[string]string{}
+	set := func(key string, val string) {
+		if val != "" {
+			values[key] = val
+		}
+	}
+	setInt := func(key string, val *int) {
+		if val != nil {
+			values[key] = strconv.Itoa(*val)
+		}
+	}
+	setBool := func(key string, val *bool) {
+		if val != nil {
+			values[key] = strconv.FormatBool(*val)
+		}
+	}
+
+	set("API_BASE_URL", c.APIBaseURL)
+	set("METRICS_PATH", c.MetricsPath)
+	setInt("PORT", c.Port)
+	set("LOG_LEVEL", c.LogLevel)
+	setInt("CACHE_INVALIDATION_SECONDS", c.CacheInvalidationSeconds)
+	setInt("DEVICES_CACHE_INVALIDATION_SECONDS", c.DevicesCacheInvalidationSeconds)
+	setInt("APPLIANCES_CACHE_INVALIDATION_SECONDS", c.AppliancesCacheInvalidationSeconds)
+	set("APPLIANCES_MODE", c.AppliancesMode)
+	set("LABEL_MODE", c.LabelMode)
+	set("NAMESPACE", c.Namespace)
+	set("METRIC_NAMES", c.MetricNames)
+	setBool("SCHEMA_DRIFT_DETECTION", c.SchemaDriftDetection)
+	setInt("TOKEN_FILE_RELOAD_SECONDS", c.TokenFileReloadSeconds)
+	setInt("AUTH_RETRY_SECONDS", c.AuthRetrySeconds)
+	set("PROBE_CREDENTIALS", c.ProbeCredentials)
+	set("WEB_CONFIG_FILE", c.WebConfigFile)
+	set("WEB_LISTEN_ADDRESS", strings.Join(c.WebListenAddress, ","))
+	setInt("SHUTDOWN_TIMEOUT_SECONDS", c.ShutdownTimeoutSeconds)
+	setBool(collectorEnv(CollectorSensors), c.Collectors.Sensors)
+	setBool(collectorEnv(CollectorEnergy), c.Collectors.Energy)
+	setBool(collectorEnv(CollectorAppliances), c.Collectors.Appliances)
+	setBool(collectorEnv(CollectorRateLimit), c.Collectors.RateLimit)
+	setBool(collectorEnv(CollectorSelf), c.Collectors.Self)
+
+	setInt("HTTP_RETRIES", c.HTTP.Retries)
+	set("HTTP_PROXY_URL", c.HTTP.ProxyURL)
+	set("HTTP_CA_FILE", c.HTTP.CAFile)
+	set("HTTP_CLIENT_CERT_FILE", c.HTTP.ClientCertFile)
+	set("HTTP_CLIENT_KEY_FILE", c.HTTP.ClientKeyFile)
+	set("HTTP_TLS_MIN_VERSION", c.HTTP.TLSMinVersion)
+	setBool("HTTP_INSECURE_SKIP_VERIFY", c.HTTP.InsecureSkipVerify)
+
+	c.fileToken.setValues(set, "")
+	names := []string{}
+	for _, a := range c.Accounts {
+		names = append(names, a.Name)
+		prefix := accountEnvPrefix(a.Name)
+		a.fileToken.setValues(set, prefix)
+		set(prefix+"API_BASE_URL", a.APIBaseURL)
+	}
+	set("ACCOUNTS", strings.Join(names, ","))
+
+	return values
+}
+
+func (t *fileToken) setValues(set func(key string, val string), prefix string) {
+	set(prefix+"OAUTH_TOKEN", t.OAuthToken)
+	set(prefix+"OAUTH_TOKEN_FILE", t.OAuthTokenFile)
+	set(prefix+"OAUTH_TOKEN_COMMAND", t.OAuthTokenCommand)
+	set(prefix+"OAUTH_TOKEN_URL", t.OAuthTokenURL)
+	set(prefix+"OAUTH_TOKEN_ENCRYPTED_FILE", t.OAuthTokenEncryptedFile)
+	set(prefix+"OAUTH_TOKEN_KEY_FILE", t.OAuthTokenKeyFile)
+}
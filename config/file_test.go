@@ -0,0 +1,194 @@
+package config_test
+
+import (
+	"os"
+
+	"github.com/golang/mock/gomock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kenfdev/remo-exporter/config"
+	"github.com/kenfdev/remo-exporter/mocks"
+)
+
+var _ = Describe("Config file", func() {
+	const (
+		configFile string = "path/to/config.yml"
+	)
+
+	var (
+		mockCtrl   *gomock.Controller
+		mockReader *mocks.MockReader
+	)
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockReader = mocks.NewMockReader(mockCtrl)
+	})
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	Describe("NewConfigFromFile", func() {
+		It("should read the settings from the file", func() {
+			mockReader.EXPECT().ReadFile(configFile).Return([]byte(`
+metrics_path: /custom
+port: 9999
+cache_invalidation_seconds: 30
+appliances_mode: auto
+http:
+  retries: 3
+accounts:
+  - name: home
+    oauth_token_file: path/to/home
+  - name: office
+    oauth_token: office_token
+    api_base_url: https://path.to/office
+`), nil)
+			mockReader.EXPECT().ReadFile("path/to/home").Return([]byte("home_token"), nil)
+
+			c, err := NewConfigFromFile(mockReader, configFile)
+
+			Expect(err).Should(BeNil())
+			Expect(c.MetricsPath).To(Equal("/custom"))
+			Expect(c.ListenPort).To(Equal("9999"))
+			Expect(c.CacheInvalidationSeconds).To(Equal(30))
+			Expect(c.DevicesCacheInvalidationSeconds).To(Equal(30))
+			Expect(c.AppliancesMode).To(Equal(AppliancesModeAuto))
+			Expect(c.HTTPRetries).To(Equal(3))
+			Expect(c.Accounts).To(HaveLen(2))
+			Expect(c.Accounts[0].OAuthToken).To(Equal("home_token"))
+			Expect(c.Accounts[1].OAuthToken).To(Equal("office_token"))
+			Expect(c.Accounts[1].APIBaseURL).To(Equal("https://path.to/office"))
+		})
+
+		It("should compile the filters", func() {
+			mockReader.EXPECT().ReadFile(configFile).Return([]byte(`
+oauth_token: some_token
+filters:
+  include:
+    - appliance_type: EL_SMART_METER
+  exclude:
+    - device_name: (?i)test
+`), nil)
+
+			c, err := NewConfigFromFile(mockReader, configFile)
+
+			Expect(err).Should(BeNil())
+			Expect(c.Filters.Include).To(HaveLen(1))
+			Expect(c.Filters.Include[0].ApplianceType).To(Equal("EL_SMART_METER"))
+			Expect(c.Filters.Include[0].IsDeviceRule()).To(BeFalse())
+			Expect(c.Filters.Exclude).To(HaveLen(1))
+			Expect(c.Filters.Exclude[0].DeviceName.MatchString("Test Remo")).To(BeTrue())
+		})
+
+		It("should compile the labels", func() {
+			mockReader.EXPECT().ReadFile(configFile).Return([]byte(`
+oauth_token: some_token
+labels:
+  devices:
+    remo-1:
+      room: living
+      floor: "2"
+  appliances:
+    meter-1:
+      floor: "1"
+      room: entrance
+`), nil)
+
+			c, err := NewConfigFromFile(mockReader, configFile)
+
+			Expect(err).Should(BeNil())
+			Expect(c.Labels.Keys).To(Equal([]string{"floor", "room"}))
+			Expect(c.Labels.DeviceValues("remo-1")).To(Equal([]string{"2", "living"}))
+			Expect(c.Labels.DeviceValues("unknown")).To(Equal([]string{"", ""}))
+			Expect(c.Labels.ApplianceValues("meter-1", "remo-1")).To(Equal([]string{"1", "entrance"}))
+			Expect(c.Labels.ApplianceValues("unknown", "remo-1")).To(Equal([]string{"2", "living"}))
+		})
+
+		It("should read the listen addresses", func() {
+			mockReader.EXPECT().ReadFile(configFile).Return([]byte("oauth_token: some_token\nweb_listen_address:\n  - 127.0.0.1:9352\n  - \"[::1]:9352\"\n  - unix:/run/remo-exporter.sock\nshutdown_timeout_seconds: 5\n"), nil)
+
+			c, err := NewConfigFromFile(mockReader, configFile)
+
+			Expect(err).Should(BeNil())
+			Expect(c.ListenAddresses).To(Equal([]string{"127.0.0.1:9352", "[::1]:9352", "unix:/run/remo-exporter.sock"}))
+			Expect(c.ShutdownTimeoutSeconds).To(Equal(5))
+		})
+
+		Context("environment variables set", func() {
+			var (
+				orgPort string
+			)
+			BeforeEach(func() {
+				orgPort = os.Getenv("PORT")
+				os.Setenv("PORT", "8888")
+			})
+			AfterEach(func() {
+				os.Setenv("PORT", orgPort)
+			})
+			It("should override the values of the file", func() {
+				mockReader.EXPECT().ReadFile(configFile).Return([]byte("oauth_token: some_token\nport: 9999\n"), nil)
+
+				c, err := NewConfigFromFile(mockReader, configFile)
+
+				Expect(err).Should(BeNil())
+				Expect(c.ListenPort).To(Equal("8888"))
+				Expect(c.OAuthToken).To(Equal("some_token"))
+			})
+		})
+
+		Context("oauth token set by an environment variable", func() {
+			var (
+				orgToken string
+			)
+			BeforeEach(func() {
+				orgToken = os.Getenv("OAUTH_TOKEN")
+				os.Setenv("OAUTH_TOKEN", "envtoken")
+			})
+			AfterEach(func() {
+				os.Setenv("OAUTH_TOKEN", orgToken)
+			})
+			It("should override the token file of the file", func() {
+				mockReader.EXPECT().ReadFile(configFile).Return([]byte("oauth_token_file: /run/secrets/remo-token\n"), nil)
+
+				c, err := NewConfigFromFile(mockReader, configFile)
+
+				Expect(err).Should(BeNil())
+				Expect(c.OAuthToken).To(Equal("envtoken"))
+				Expect(c.Accounts[0].TokenProvider).To(BeNil())
+			})
+		})
+
+		DescribeTable("should reject invalid files with the offending line",
+			func(content string, expected string) {
+				mockReader.EXPECT().ReadFile(configFile).Return([]byte(content), nil)
+
+				c, err := NewConfigFromFile(mockReader, configFile)
+
+				Expect(c).To(BeNil())
+				Expect(err).NotTo(BeNil())
+				Expect(err.Error()).To(ContainSubstring(expected))
+			},
+			Entry("unknown key", "oauth_token: some_token\nfoo: bar\n", "path/to/config.yml:2: field foo not found"),
+			Entry("negative ttl", "oauth_token: some_token\n\ncache_invalidation_seconds: -1\n", "path/to/config.yml:3: cache_invalidation_seconds must not be negative"),
+			Entry("metrics path without a leading slash", "metrics_path: metrics\n", "path/to/config.yml:1: metrics_path \"metrics\" must start with /"),
+			Entry("malformed base url", "accounts:\n  - name: home\n    api_base_url: api.nature.global\n", "path/to/config.yml:3: invalid api_base_url"),
+			Entry("wrong type", "port: abc\n", "path/to/config.yml:1: cannot unmarshal"),
+			Entry("invalid filter regex", "filters:\n  exclude:\n    - device_name: \"(\"\n", "path/to/config.yml:3: invalid device_name"),
+			Entry("invalid namespace", "oauth_token: some_token\nnamespace: nature-remo\n", "path/to/config.yml:2: invalid namespace"),
+			Entry("unknown metric names", "oauth_token: some_token\nmetric_names: v3\n", "path/to/config.yml:2: metric_names must be one of v1, v2 or both"),
+			Entry("unknown collector", "oauth_token: some_token\ncollectors:\n  battery: true\n", "path/to/config.yml:3: field battery not found"),
+			Entry("unknown label mode", "oauth_token: some_token\nlabel_mode: nickname\n", "path/to/config.yml:2: label_mode must be one of name, id or both"),
+			Entry("labels with different keys", "labels:\n  devices:\n    a:\n      room: living\n    b:\n      floor: \"2\"\n", "path/to/config.yml:6: the labels of b must have the same keys"),
+			Entry("reserved label", "labels:\n  devices:\n    a:\n      name: living\n", "path/to/config.yml:4: label \"name\" is reserved"),
+			Entry("invalid label name", "labels:\n  appliances:\n    a:\n      my-room: living\n", "path/to/config.yml:4: invalid label name"),
+			Entry("listen address without a port", "oauth_token: some_token\nweb_listen_address:\n  - :9352\n  - 127.0.0.1\n", "path/to/config.yml:4: invalid listen address"),
+			Entry("account name with a comma", "accounts:\n  - name: home,office\n    oauth_token: a\n", "path/to/config.yml:2: account name \"home,office\" must match"),
+			Entry("account name with a space", "accounts:\n  - name: \" home\"\n    oauth_token: a\n", "path/to/config.yml:2: account name \" home\" must match"),
+			Entry("accounts sharing their variables", "accounts:\n  - name: Home\n    oauth_token: a\n  - name: home\n    oauth_token: b\n", "path/to/config.yml:4: accounts Home and home would both be configured by ACCOUNT_HOME_* variables"),
+			Entry("filter rule with two fields", "filters:\n  include:\n    - device_id: a\n      appliance_type: AC\n", "path/to/config.yml:3: a filter rule must have exactly one"),
+		)
+	})
+})
@@ -0,0 +1,25 @@
+package config
+
+import "regexp"
+
+// FilterRule matches devices or appliances. Exactly one of the fields is set.
+// IDs and types match exactly, names and nicknames are regular expressions.
+type FilterRule struct {
+	DeviceID          string
+	DeviceName        *regexp.Regexp
+	ApplianceType     string
+	ApplianceNickname *regexp.Regexp
+}
+
+// IsDeviceRule reports whether the rule matches devices rather than appliances
+func (r *FilterRule) IsDeviceRule() bool {
+	return r.DeviceID != "" || r.DeviceName != nil
+}
+
+// Filters decide which devices and appliances are exported.
+// An entity is exported if any include rule of its kind matches, or there is none,
+// and no exclude rule matches. Appliances of an excluded device are excluded as well.
+type Filters struct {
+	Include []*FilterRule
+	Exclude []*FilterRule
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// flagSetting is a setting of the Config which can be set by a command-line flag.
+// The flag overrides the environment variable env.
+type flagSetting struct {
+	env    string
+	isBool bool
+	help   string
+}
+
+var flagSettings = []flagSetting{
+	{env: "OAUTH_TOKEN", help: "The oauth token of the default account. Prefer --oauth-token-file"},
+	{env: "OAUTH_TOKEN_FILE", help: "The path to the file with the oauth token of the default account"},
+	{env: "OAUTH_TOKEN_COMMAND", help: "A command whose output is the oauth token of the default account"},
+	{env: "OAUTH_TOKEN_URL", help: "A secret endpoint responding with the oauth token of the default account"},
+	{env: "OAUTH_TOKEN_ENCRYPTED_FILE", help: "A NaCl secretbox encrypted file with the oauth token of the default account"},
+	{env: "OAUTH_TOKEN_KEY_FILE", help: "The key of --oauth-token-encrypted-file"},
+	{env: "ACCOUNTS", help: "Comma separated account names configured by ACCOUNT_<NAME>_* environment variables"},
+	{env: "API_BASE_URL", help: "The Remo API base URL (default https://api.nature.global)"},
+	{env: "METRICS_PATH", help: "The metrics URL path (default /metrics)"},
+	{env: "PORT", help: "The port to be used by the exporter (default 9352)"},
+	{env: "WEB_LISTEN_ADDRESS", help: "Comma separated addresses to listen on, e.g. 127.0.0.1:9352, [::1]:9352 or unix:/run/remo-exporter.sock (default :<port>)"},
+	{env: "SHUTDOWN_TIMEOUT_SECONDS", help: "How long in-flight requests are waited for on SIGTERM or SIGINT (default 20)"},
+	{env: "LOG_LEVEL", help: "The log level: debug, info, warn or error (default info)"},
+	{env: "CACHE_INVALIDATION_SECONDS", help: "The period in seconds the results of the Remo API are cached (default 60)"},
+	{env: "DEVICES_CACHE_INVALIDATION_SECONDS", help: "The cache period in seconds for /1/devices (default --cache-invalidation-seconds)"},
+	{env: "APPLIANCES_CACHE_INVALIDATION_SECONDS", help: "The cache period in seconds for /1/appliances (default --cache-invalidation-seconds)"},
+	{env: "APPLIANCES_MODE", help: "How /1/appliances is requested: enabled, disabled or auto (default enabled)"},
+	{env: "NAMESPACE", help: "The prefix of the metric names (default remo)"},
+	{env: "METRIC_NAMES", help: "The naming scheme of the metrics: v1, v2 or both (default v1)"},
+	{env: "LABEL_MODE", help: "The labels of the device and appliance series: name, id or both (default name)"},
+	{env: "SCHEMA_DRIFT_DETECTION", isBool: true, help: "Report fields of the Remo API responses unknown to the exporter"},
+	{env: "TOKEN_FILE_RELOAD_SECONDS", help: "How often the watched oauth token files are checked for a new token in addition. 0 disables the periodic check (default 30)"},
+	{env: "AUTH_RETRY_SECONDS", help: "The period in seconds requests are retried while the oauth token is rejected (default 300)"},
+	{env: "COLLECTOR_SENSORS", isBool: true, help: "Enable the sensors collector (default true)"},
+	{env: "COLLECTOR_ENERGY", isBool: true, help: "Enable the energy collector (default true)"},
+	{env: "COLLECTOR_APPLIANCES", isBool: true, help: "Enable the appliances collector (default true)"},
+	{env: "COLLECTOR_RATELIMIT", isBool: true, help: "Enable the ratelimit collector (default true)"},
+	{env: "COLLECTOR_SELF", isBool: true, help: "Enable the self collector (default true)"},
+	{env: "PROBE_CREDENTIALS", help: "A directory or file with the oauth tokens of the /probe targets"},
+	{env: "WEB_CONFIG_FILE", help: "A web configuration file in the exporter-toolkit format enabling TLS and basic auth"},
+	{env: "HTTP_RETRIES", help: "The number of retries of requests to the Remo API on network errors and 5xx responses (default 0)"},
+	{env: "HTTP_PROXY_URL", help: "The proxy for the requests to the Remo API"},
+	{env: "HTTP_CA_FILE", help: "A PEM file with CA certificates trusted in addition to the system ones"},
+	{env: "HTTP_CLIENT_CERT_FILE", help: "The PEM file of a client certificate presented to the Remo API"},
+	{env: "HTTP_CLIENT_KEY_FILE", help: "The PEM file of the key of --http.client-cert-file"},
+	{env: "HTTP_TLS_MIN_VERSION", help: "The minimum TLS version: 1.0, 1.1, 1.2 or 1.3 (default 1.2)"},
+	{env: "HTTP_INSECURE_SKIP_VERIFY", isBool: true, help: "Skip the TLS certificate verification. Not recommended"},
+}
+
+// flagName returns the flag of an environment variable, e.g. --http.proxy-url for HTTP_PROXY_URL
+// and --collector.sensors for COLLECTOR_SENSORS. WEB_CONFIG_FILE and WEB_LISTEN_ADDRESS are --web.config.file
+// and --web.listen-address like in other exporters.
+func flagName(env string) string {
+	name := strings.ToLower(env)
+	for _, prefix := range []string{"http", "collector", "web_config", "web"} {
+		if strings.HasPrefix(name, prefix+"_") {
+			name = strings.ReplaceAll(prefix, "_", ".") + "." + strings.TrimPrefix(name, prefix+"_")
+			break
+		}
+	}
+	return strings.ReplaceAll(name, "_", "-")
+}
+
+// settingValue is the flag.Value of a flagSetting
+type settingValue struct {
+	setting flagSetting
+	value   string
+}
+
+func (v *settingValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.value
+}
+
+func (v *settingValue) Set(s string) error {
+	if v.setting.isBool {
+		if _, err := strconv.ParseBool(s); err != nil {
+			return err
+		}
+	}
+	v.value = s
+	return nil
+}
+
+func (v *settingValue) IsBoolFlag() bool {
+	return v.setting.isBool
+}
+
+// Flags holds the command-line flags. Flags which are set override the environment variables.
+type Flags struct {
+	ConfigFile string
+
+	fs *flag.FlagSet
+}
+
+// RegisterFlags defines a flag for every setting of the Config on fs
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{fs: fs}
+	fs.StringVar(&f.ConfigFile, "config.file", "", "Path to the YAML configuration file. Environment variables and flags override its values")
+	for _, s := range flagSettings {
+		fs.Var(&settingValue{setting: s}, flagName(s.env), fmt.Sprintf("%s. Env: %s", s.help, s.env))
+	}
+	return f
+}
+
+// setValues returns the values of the flags which were set keyed by their environment variable
+func (f *Flags) setValues() map[string]string {
+	values := map[string]string{}
+	f.fs.Visit(func(fl *flag.Flag) {
+		if v, ok := fl.Value.(*settingValue); ok {
+			values[v.setting.env] = v.value
+		}
+	})
+	return values
+}
+
+// Load creates a new config from the flags, the environment variables and the configuration file
+// of --config.file in this order of precedence
+func Load(r Reader, f *Flags) (*Config, error) {
+	env := &environment{}
+	if f.ConfigFile != "" {
+		fc, err := readConfigFile(r, f.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		env = fc.environment()
+	}
+	env.flags = f.setValues()
+	return newConfig(r, env)
+}
@@ -0,0 +1,126 @@
+package config_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+
+	"github.com/golang/mock/gomock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kenfdev/remo-exporter/config"
+	"github.com/kenfdev/remo-exporter/mocks"
+)
+
+var _ = Describe("Flags", func() {
+	var (
+		mockCtrl   *gomock.Controller
+		mockReader *mocks.MockReader
+		fs         *flag.FlagSet
+		flags      *Flags
+
+		orgOAuthToken string
+		orgPort       string
+	)
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockReader = mocks.NewMockReader(mockCtrl)
+		fs = flag.NewFlagSet("remo-exporter", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		flags = RegisterFlags(fs)
+
+		orgOAuthToken = os.Getenv("OAUTH_TOKEN")
+		orgPort = os.Getenv("PORT")
+		os.Setenv("OAUTH_TOKEN", "env_token")
+		os.Setenv("PORT", "8888")
+	})
+	AfterEach(func() {
+		os.Setenv("OAUTH_TOKEN", orgOAuthToken)
+		os.Setenv("PORT", orgPort)
+		mockCtrl.Finish()
+	})
+
+	It("should override the environment variables", func() {
+		err := fs.Parse([]string{"--port=9999", "--http.retries", "2", "--schema-drift-detection"})
+		Expect(err).Should(BeNil())
+
+		c, err := Load(mockReader, flags)
+
+		Expect(err).Should(BeNil())
+		Expect(c.ListenPort).To(Equal("9999"))
+		Expect(c.HTTPRetries).To(Equal(2))
+		Expect(c.SchemaDriftDetection).To(BeTrue())
+		Expect(c.OAuthToken).To(Equal("env_token"))
+	})
+
+	It("should fall back to the environment variables and the config file", func() {
+		mockReader.EXPECT().ReadFile("path/to/config.yml").Return([]byte("port: 7777\nmetrics_path: /custom\n"), nil)
+		err := fs.Parse([]string{"--config.file", "path/to/config.yml"})
+		Expect(err).Should(BeNil())
+
+		c, err := Load(mockReader, flags)
+
+		Expect(err).Should(BeNil())
+		Expect(c.ListenPort).To(Equal("8888"))
+		Expect(c.MetricsPath).To(Equal("/custom"))
+	})
+
+	It("should disable collector groups", func() {
+		mockReader.EXPECT().ReadFile("path/to/config.yml").Return([]byte("collectors:\n  self: false\n  ratelimit: false\n"), nil)
+		err := fs.Parse([]string{"--config.file", "path/to/config.yml", "--collector.energy=false", "--collector.self"})
+		Expect(err).Should(BeNil())
+
+		c, err := Load(mockReader, flags)
+
+		Expect(err).Should(BeNil())
+		Expect(c.Collectors).To(Equal([]string{CollectorSensors, CollectorAppliances, CollectorSelf}))
+	})
+
+	It("should set the web config file like other exporters", func() {
+		err := fs.Parse([]string{"--web.config.file", "web.yml"})
+		Expect(err).Should(BeNil())
+
+		c, err := Load(mockReader, flags)
+
+		Expect(err).Should(BeNil())
+		Expect(c.WebConfigFile).To(Equal("web.yml"))
+	})
+
+	It("should listen on the addresses of the flag instead of the port", func() {
+		err := fs.Parse([]string{"--web.listen-address", "127.0.0.1:9352, unix:/run/remo-exporter.sock"})
+		Expect(err).Should(BeNil())
+
+		c, err := Load(mockReader, flags)
+
+		Expect(err).Should(BeNil())
+		Expect(c.ListenAddresses).To(Equal([]string{"127.0.0.1:9352", "unix:/run/remo-exporter.sock"}))
+	})
+
+	It("should default to the port", func() {
+		err := fs.Parse([]string{})
+		Expect(err).Should(BeNil())
+
+		c, err := Load(mockReader, flags)
+
+		Expect(err).Should(BeNil())
+		Expect(c.ListenAddresses).To(Equal([]string{":8888"}))
+	})
+
+	It("should reject an invalid listen address", func() {
+		err := fs.Parse([]string{"--web.listen-address", "unix:"})
+		Expect(err).Should(BeNil())
+
+		_, err = Load(mockReader, flags)
+
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("Invalid WEB_LISTEN_ADDRESS"))
+	})
+
+	It("should reject an invalid boolean", func() {
+		err := fs.Parse([]string{"--http.insecure-skip-verify=maybe"})
+
+		Expect(err).NotTo(BeNil())
+	})
+})
@@ -0,0 +1,46 @@
+package config
+
+// StaticLabels are added to every series of a device or appliance.
+// Every configured entity has the same label Keys, which are sorted.
+type StaticLabels struct {
+	Keys       []string
+	Devices    map[string]map[string]string
+	Appliances map[string]map[string]string
+}
+
+// LabelKeys returns the keys of the labels or nil if no labels are configured
+func (l *StaticLabels) LabelKeys() []string {
+	if l == nil {
+		return nil
+	}
+	return l.Keys
+}
+
+// DeviceValues returns the label values of the device in the order of Keys.
+// The values are empty if no labels are configured for the device.
+func (l *StaticLabels) DeviceValues(deviceID string) []string {
+	if l == nil {
+		return nil
+	}
+	return l.values(l.Devices[deviceID])
+}
+
+// ApplianceValues returns the label values of the appliance in the order of Keys.
+// The labels of the device of the appliance are used if none are configured for the appliance.
+func (l *StaticLabels) ApplianceValues(applianceID string, deviceID string) []string {
+	if l == nil {
+		return nil
+	}
+	if labels, ok := l.Appliances[applianceID]; ok {
+		return l.values(labels)
+	}
+	return l.values(l.Devices[deviceID])
+}
+
+func (l *StaticLabels) values(labels map[string]string) []string {
+	values := make([]string, len(l.Keys))
+	for i, key := range l.Keys {
+		values[i] = labels[key]
+	}
+	return values
+}
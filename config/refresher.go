@@ -0,0 +1,132 @@
+package config
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/kenfdev/remo-exporter/log"
+)
+
+// refreshBeforeExpiry is how long before its expiry a token is fetched again
+const refreshBeforeExpiry = 5 * time.Minute
+
+// TokenRefresher keeps the oauth token fetched by a SecretProvider up to date.
+// OnLoad is called after every successful fetch with the token and whether it changed.
+type TokenRefresher struct {
+	provider SecretProvider
+	OnLoad   func(token string, changed bool, loadedAt time.Time)
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// NewTokenRefresher returns a TokenRefresher for provider which last fetched secret
+func NewTokenRefresher(provider SecretProvider, secret *Secret) *TokenRefresher {
+	return &TokenRefresher{
+		provider:  provider,
+		token:     secret.Token,
+		expiresAt: secret.ExpiresAt,
+	}
+}
+
+// Reload fetches the token and reports whether it changed
+func (t *TokenRefresher) Reload() (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	secret, err := t.provider.Fetch()
+	if err != nil {
+		return false, err
+	}
+	if secret.Token == "" {
+		return false, errors.New("the fetched oauth token is empty")
+	}
+
+	changed := secret.Token != t.token
+	t.token = secret.Token
+	t.expiresAt = secret.ExpiresAt
+	if t.OnLoad != nil {
+		t.OnLoad(secret.Token, changed, time.Now())
+	}
+	return changed, nil
+}
+
+// expiresSoon reports whether the token expires within refreshBeforeExpiry
+func (t *TokenRefresher) expiresSoon(now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return !t.expiresAt.IsZero() && now.Add(refreshBeforeExpiry).After(t.expiresAt)
+}
+
+// Watch checks the token every interval until stop is closed. It is fetched again on every check
+// if poll is true, e.g. for a token file, and otherwise only when it is about to expire.
+func (t *TokenRefresher) Watch(interval time.Duration, poll bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if !poll && !t.expiresSoon(now) {
+				continue
+			}
+			if _, err := t.Reload(); err != nil {
+				log.Errorf("Reloading the oauth token failed: %v", err)
+			}
+		}
+	}
+}
+
+// kubernetesDataLink is the symlink which Kubernetes swaps to update the files of a mounted secret
+const kubernetesDataLink = "..data"
+
+// WatchFile fetches the token again whenever the file at path changes until stop is closed.
+// The directory of the file is watched, so a file which is replaced, e.g. by a rename or by
+// Kubernetes updating a mounted secret, is noticed as well.
+func (t *TokenRefresher) WatchFile(path string, stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-stop:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				name := filepath.Clean(event.Name)
+				if name != filepath.Clean(path) && filepath.Base(name) != kubernetesDataLink {
+					continue
+				}
+				if _, err := t.Reload(); err != nil {
+					log.Errorf("Reloading the oauth token file %s failed: %v", path, err)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("Watching the oauth token file %s failed: %v", path, err)
+			}
+		}
+	}()
+	return nil
+}
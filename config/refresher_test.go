@@ -0,0 +1,137 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/mock/gomock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kenfdev/remo-exporter/config"
+	"github.com/kenfdev/remo-exporter/mocks"
+)
+
+var _ = Describe("TokenRefresher", func() {
+	const (
+		tokenFile string = "path/to/token"
+	)
+
+	var (
+		mockCtrl   *gomock.Controller
+		mockReader *mocks.MockReader
+		tr         *TokenRefresher
+		loaded     []string
+	)
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockReader = mocks.NewMockReader(mockCtrl)
+		loaded = []string{}
+		tr = NewTokenRefresher(NewFileSecretProvider(mockReader, tokenFile), &Secret{Token: "old_token"})
+		tr.OnLoad = func(token string, changed bool, loadedAt time.Time) {
+			if changed {
+				loaded = append(loaded, token)
+			}
+		}
+	})
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("should report a changed token", func() {
+		mockReader.EXPECT().ReadFile(tokenFile).Return([]byte("new_token\n"), nil)
+
+		changed, err := tr.Reload()
+
+		Expect(err).Should(BeNil())
+		Expect(changed).To(BeTrue())
+		Expect(loaded).To(Equal([]string{"new_token"}))
+	})
+
+	It("should not report an unchanged token", func() {
+		mockReader.EXPECT().ReadFile(tokenFile).Return([]byte("old_token"), nil)
+
+		changed, err := tr.Reload()
+
+		Expect(err).Should(BeNil())
+		Expect(changed).To(BeFalse())
+		Expect(loaded).To(BeEmpty())
+	})
+
+	It("should keep the token if the file can't be read", func() {
+		mockReader.EXPECT().ReadFile(tokenFile).Return(nil, errors.New("File not found"))
+		mockReader.EXPECT().ReadFile(tokenFile).Return([]byte(""), nil)
+
+		_, err := tr.Reload()
+		Expect(err).NotTo(BeNil())
+
+		_, err = tr.Reload()
+		Expect(err).NotTo(BeNil())
+		Expect(loaded).To(BeEmpty())
+	})
+
+	Context("watching a file", func() {
+		var (
+			dir   string
+			path  string
+			stop  chan struct{}
+			loads chan string
+		)
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "token")
+			Expect(err).Should(BeNil())
+			path = filepath.Join(dir, "token")
+			Expect(os.WriteFile(path, []byte("old_token"), 0600)).Should(BeNil())
+			stop = make(chan struct{})
+			loads = make(chan string, 10)
+
+			tr = NewTokenRefresher(NewFileSecretProvider(NewFileReader(), path), &Secret{Token: "old_token"})
+			tr.OnLoad = func(token string, changed bool, loadedAt time.Time) {
+				if changed {
+					loads <- token
+				}
+			}
+			Expect(tr.WatchFile(path, stop)).Should(BeNil())
+		})
+		AfterEach(func() {
+			close(stop)
+			os.RemoveAll(dir)
+		})
+
+		It("should reload the token when the file is written", func() {
+			Expect(os.WriteFile(path, []byte("new_token"), 0600)).Should(BeNil())
+
+			Eventually(loads).Should(Receive(Equal("new_token")))
+		})
+
+		It("should reload the token when the file is replaced", func() {
+			tmp := filepath.Join(dir, "token.tmp")
+			Expect(os.WriteFile(tmp, []byte("new_token"), 0600)).Should(BeNil())
+			Expect(os.Rename(tmp, path)).Should(BeNil())
+
+			Eventually(loads).Should(Receive(Equal("new_token")))
+		})
+
+		It("should reload the token when kubernetes updates the secret", func() {
+			// a mounted secret links token to ..data/token and swaps the ..data link on updates
+			for _, version := range []string{"..v1", "..v2"} {
+				Expect(os.Mkdir(filepath.Join(dir, version), 0700)).Should(BeNil())
+			}
+			Expect(os.WriteFile(filepath.Join(dir, "..v1", "token"), []byte("old_token"), 0600)).Should(BeNil())
+			Expect(os.WriteFile(filepath.Join(dir, "..v2", "token"), []byte("new_token"), 0600)).Should(BeNil())
+			Expect(os.Symlink("..v1", filepath.Join(dir, "..data"))).Should(BeNil())
+			Expect(os.Remove(path)).Should(BeNil())
+			Expect(os.Symlink(filepath.Join("..data", "token"), path)).Should(BeNil())
+			Consistently(loads, 100*time.Millisecond).ShouldNot(Receive())
+
+			Expect(os.Symlink("..v2", filepath.Join(dir, "..data_tmp"))).Should(BeNil())
+			Expect(os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data"))).Should(BeNil())
+
+			Eventually(loads).Should(Receive(Equal("new_token")))
+		})
+	})
+})
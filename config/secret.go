@@ -0,0 +1,222 @@
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	// secretFetchTimeout limits how long a command or secret endpoint may take
+	secretFetchTimeout = 30 * time.Second
+	secretKeyLength    = 32
+	secretNonceLength  = 24
+)
+
+// Secret is an oauth token fetched by a SecretProvider.
+// ExpiresAt is zero if the provider didn't tell when the token expires.
+type Secret struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// SecretProvider fetches the oauth token of an account
+type SecretProvider interface {
+	Fetch() (*Secret, error)
+}
+
+// secretResponse is the JSON which commands and secret endpoints may respond with
+// instead of the plain token
+type secretResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ExpiresIn int       `json:"expires_in"`
+}
+
+// parseSecret reads either a plain token or a JSON object with the token and its expiry
+func parseSecret(data []byte, now time.Time) (*Secret, error) {
+	data = []byte(strings.TrimSpace(string(data)))
+	if len(data) == 0 || data[0] != '{' {
+		return &Secret{Token: string(data)}, nil
+	}
+
+	res := &secretResponse{}
+	if err := json.Unmarshal(data, res); err != nil {
+		return nil, fmt.Errorf("failed to decode the secret: %w", err)
+	}
+	secret := &Secret{
+		Token:     strings.TrimSpace(res.Token),
+		ExpiresAt: res.ExpiresAt,
+	}
+	if secret.ExpiresAt.IsZero() && res.ExpiresIn > 0 {
+		secret.ExpiresAt = now.Add(time.Duration(res.ExpiresIn) * time.Second)
+	}
+	return secret, nil
+}
+
+// FileSecretProvider reads the token from a plaintext file
+type FileSecretProvider struct {
+	reader Reader
+	path   string
+}
+
+// NewFileSecretProvider returns a FileSecretProvider for path
+func NewFileSecretProvider(r Reader, path string) *FileSecretProvider {
+	return &FileSecretProvider{
+		reader: r,
+		path:   path,
+	}
+}
+
+// Fetch reads the token file
+func (p *FileSecretProvider) Fetch() (*Secret, error) {
+	data, err := p.reader.ReadFile(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load oauth token file at: %s. %s", p.path, err.Error())
+	}
+	return &Secret{Token: strings.TrimSpace(string(data))}, nil
+}
+
+// ExecSecretProvider runs a command, e.g. a wrapper around pass, op or sops, and reads the token from its output.
+// The command is split on whitespace and isn't run by a shell.
+type ExecSecretProvider struct {
+	command []string
+}
+
+// NewExecSecretProvider returns an ExecSecretProvider for command
+func NewExecSecretProvider(command string) (*ExecSecretProvider, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("the oauth token command is empty")
+	}
+	return &ExecSecretProvider{command: args}, nil
+}
+
+// Fetch runs the command
+func (p *ExecSecretProvider) Fetch() (*Secret, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretFetchTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, p.command[0], p.command[1:]...)
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("Unable to run the oauth token command %s. %s: %s", p.command[0], err.Error(), strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("Unable to run the oauth token command %s. %s", p.command[0], err.Error())
+	}
+	return parseSecret(out, time.Now())
+}
+
+// EncryptedFileSecretProvider reads the token from a file encrypted with NaCl secretbox.
+// The file holds the 24 byte nonce followed by the sealed box, either raw or base64 encoded,
+// and the key file holds the 32 byte key, either raw, hex or base64 encoded.
+type EncryptedFileSecretProvider struct {
+	reader  Reader
+	path    string
+	keyPath string
+}
+
+// NewEncryptedFileSecretProvider returns an EncryptedFileSecretProvider for path which is decrypted with the key at keyPath
+func NewEncryptedFileSecretProvider(r Reader, path string, keyPath string) *EncryptedFileSecretProvider {
+	return &EncryptedFileSecretProvider{
+		reader:  r,
+		path:    path,
+		keyPath: keyPath,
+	}
+}
+
+// Fetch reads and decrypts the token file
+func (p *EncryptedFileSecretProvider) Fetch() (*Secret, error) {
+	keyData, err := p.reader.ReadFile(p.keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load oauth token key file at: %s. %s", p.keyPath, err.Error())
+	}
+	key, err := decodeSecretKey(keyData)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid oauth token key file at: %s. %s", p.keyPath, err.Error())
+	}
+
+	data, err := p.reader.ReadFile(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load encrypted oauth token file at: %s. %s", p.path, err.Error())
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data))); err == nil {
+		data = decoded
+	}
+	if len(data) < secretNonceLength+secretbox.Overhead {
+		return nil, fmt.Errorf("Invalid encrypted oauth token file at: %s. The file is too short", p.path)
+	}
+
+	var nonce [secretNonceLength]byte
+	copy(nonce[:], data[:secretNonceLength])
+	plain, ok := secretbox.Open(nil, data[secretNonceLength:], &nonce, key)
+	if !ok {
+		return nil, fmt.Errorf("Unable to decrypt the oauth token file at: %s", p.path)
+	}
+	return parseSecret(plain, time.Now())
+}
+
+// decodeSecretKey accepts a raw, hex or base64 encoded 32 byte key
+func decodeSecretKey(data []byte) (*[secretKeyLength]byte, error) {
+	var key [secretKeyLength]byte
+	if len(data) == secretKeyLength {
+		copy(key[:], data)
+		return &key, nil
+	}
+
+	s := strings.TrimSpace(string(data))
+	if decoded, err := hex.DecodeString(s); err == nil && len(decoded) == secretKeyLength {
+		copy(key[:], decoded)
+		return &key, nil
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(s); err == nil && len(decoded) == secretKeyLength {
+		copy(key[:], decoded)
+		return &key, nil
+	}
+	return nil, fmt.Errorf("the key must be %d bytes, either raw, hex or base64 encoded", secretKeyLength)
+}
+
+// HTTPSecretProvider requests the token from a secret endpoint.
+// Credentials for the endpoint can be given as user info of the URL.
+type HTTPSecretProvider struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSecretProvider returns an HTTPSecretProvider for url
+func NewHTTPSecretProvider(url string) *HTTPSecretProvider {
+	return &HTTPSecretProvider{
+		url:    url,
+		client: &http.Client{Timeout: secretFetchTimeout},
+	}
+}
+
+// Fetch requests the secret endpoint
+func (p *HTTPSecretProvider) Fetch() (*Secret, error) {
+	resp, err := p.client.Get(p.url)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to request the oauth token endpoint. %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the oauth token endpoint response. %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("The oauth token endpoint responded with status %d", resp.StatusCode)
+	}
+	return parseSecret(body, time.Now())
+}
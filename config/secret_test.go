@@ -0,0 +1,133 @@
+package config_test
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"golang.org/x/crypto/nacl/secretbox"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kenfdev/remo-exporter/config"
+	"github.com/kenfdev/remo-exporter/mocks"
+)
+
+var _ = Describe("SecretProvider", func() {
+	var (
+		mockCtrl   *gomock.Controller
+		mockReader *mocks.MockReader
+	)
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockReader = mocks.NewMockReader(mockCtrl)
+	})
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	Describe("ExecSecretProvider", func() {
+		It("should read the token from the output of the command", func() {
+			p, err := NewExecSecretProvider("echo some_token")
+			Expect(err).Should(BeNil())
+
+			secret, err := p.Fetch()
+
+			Expect(err).Should(BeNil())
+			Expect(secret.Token).To(Equal("some_token"))
+			Expect(secret.ExpiresAt.IsZero()).To(BeTrue())
+		})
+		It("should read the expiry if the command outputs JSON", func() {
+			p, _ := NewExecSecretProvider(`echo {"token":"some_token","expires_at":"2030-01-01T00:00:00Z"}`)
+
+			secret, err := p.Fetch()
+
+			Expect(err).Should(BeNil())
+			Expect(secret.Token).To(Equal("some_token"))
+			Expect(secret.ExpiresAt).To(Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)))
+		})
+		It("should fail if the command fails", func() {
+			p, _ := NewExecSecretProvider("false")
+
+			_, err := p.Fetch()
+
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("EncryptedFileSecretProvider", func() {
+		const (
+			tokenFile string = "path/to/token.enc"
+			keyFile   string = "path/to/token.key"
+		)
+		var (
+			key       [32]byte
+			encrypted []byte
+		)
+		BeforeEach(func() {
+			rand.Read(key[:])
+			var nonce [24]byte
+			rand.Read(nonce[:])
+			encrypted = secretbox.Seal(nonce[:], []byte("some_token\n"), &nonce, &key)
+		})
+
+		It("should decrypt the token with a hex encoded key", func() {
+			mockReader.EXPECT().ReadFile(keyFile).Return([]byte(hex.EncodeToString(key[:])+"\n"), nil)
+			mockReader.EXPECT().ReadFile(tokenFile).Return(encrypted, nil)
+
+			secret, err := NewEncryptedFileSecretProvider(mockReader, tokenFile, keyFile).Fetch()
+
+			Expect(err).Should(BeNil())
+			Expect(secret.Token).To(Equal("some_token"))
+		})
+		It("should decrypt a base64 encoded file", func() {
+			mockReader.EXPECT().ReadFile(keyFile).Return(key[:], nil)
+			mockReader.EXPECT().ReadFile(tokenFile).Return([]byte(base64.StdEncoding.EncodeToString(encrypted)), nil)
+
+			secret, err := NewEncryptedFileSecretProvider(mockReader, tokenFile, keyFile).Fetch()
+
+			Expect(err).Should(BeNil())
+			Expect(secret.Token).To(Equal("some_token"))
+		})
+		It("should fail with the wrong key", func() {
+			var wrongKey [32]byte
+			mockReader.EXPECT().ReadFile(keyFile).Return(wrongKey[:], nil)
+			mockReader.EXPECT().ReadFile(tokenFile).Return(encrypted, nil)
+
+			_, err := NewEncryptedFileSecretProvider(mockReader, tokenFile, keyFile).Fetch()
+
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("HTTPSecretProvider", func() {
+		It("should read the token and its lifetime from the endpoint", func() {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"token": "some_token", "expires_in": 3600}`))
+			}))
+			defer ts.Close()
+
+			before := time.Now()
+			secret, err := NewHTTPSecretProvider(ts.URL).Fetch()
+
+			Expect(err).Should(BeNil())
+			Expect(secret.Token).To(Equal("some_token"))
+			Expect(secret.ExpiresAt).To(BeTemporally(">=", before.Add(time.Hour)))
+		})
+		It("should fail if the endpoint doesn't respond with 200", func() {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+			}))
+			defer ts.Close()
+
+			_, err := NewHTTPSecretProvider(ts.URL).Fetch()
+
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
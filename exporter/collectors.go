@@ -0,0 +1,90 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kenfdev/remo-exporter/config"
+)
+
+// collectorSet holds the names of collector groups
+type collectorSet map[string]bool
+
+// allCollectors holds every collector group
+var allCollectors = newCollectorSet(config.CollectorNames)
+
+func newCollectorSet(names []string) collectorSet {
+	s := collectorSet{}
+	for _, name := range names {
+		s[name] = true
+	}
+	return s
+}
+
+// enabledCollectors returns the collector groups enabled by the config.
+// All groups are enabled if the config doesn't list them.
+func enabledCollectors(c *config.Config) collectorSet {
+	if c.Collectors == nil {
+		return allCollectors
+	}
+	return newCollectorSet(c.Collectors)
+}
+
+func (s collectorSet) intersect(other collectorSet) collectorSet {
+	res := collectorSet{}
+	for name := range s {
+		if other[name] {
+			res[name] = true
+		}
+	}
+	return res
+}
+
+func (e *Exporter) getCollectors() collectorSet {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.collectors
+}
+
+// CollectorEnabled reports whether the collector group is enabled by the config
+func (e *Exporter) CollectorEnabled(name string) bool {
+	return e.getCollectors()[name]
+}
+
+// Filter returns a collector exporting only the given collector groups of the exporter,
+// e.g. for the collect[] parameters of a scrape. The groups must be enabled.
+func (e *Exporter) Filter(names []string) (prometheus.Collector, error) {
+	return e.filter(context.Background(), names)
+}
+
+// filter returns a collector of the groups whose requests to the remo API are cancelled with ctx
+func (e *Exporter) filter(ctx context.Context, names []string) (*filteredCollector, error) {
+	enabled := e.getCollectors()
+	for _, name := range names {
+		if !allCollectors[name] {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+		if !enabled[name] {
+			return nil, fmt.Errorf("disabled collector %q", name)
+		}
+	}
+	return &filteredCollector{exporter: e, groups: newCollectorSet(names), ctx: ctx}, nil
+}
+
+// filteredCollector collects a subset of the collector groups of an Exporter
+type filteredCollector struct {
+	exporter *Exporter
+	groups   collectorSet
+	ctx      context.Context
+}
+
+func (c *filteredCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.exporter.describe(c.groups, ch)
+}
+
+func (c *filteredCollector) Collect(ch chan<- prometheus.Metric) {
+	c.exporter.collect(c.ctx, c.groups, ch)
+}
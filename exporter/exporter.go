@@ -1,217 +1,511 @@
 package exporter
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 
 	"github.com/kenfdev/remo-exporter/config"
 	"github.com/kenfdev/remo-exporter/log"
 	"github.com/kenfdev/remo-exporter/types"
 )
 
-const (
-	namespace = "remo"
-)
+// apiStats holds the statistics of a single request to the remo API
+type apiStats struct {
+	api          string
+	statusCode   int
+	isCache      bool
+	meta         *types.Meta
+	fetchedAt    time.Time
+	duration     time.Duration
+	responseSize int
+}
 
-// Metrics descriptions
-var (
-	temperature = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "temperature"),
-		"The temperature of the remo device",
-		[]string{"name", "id"}, nil,
-	)
-
-	humidity = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "humidity"),
-		"The humidity of the remo device",
-		[]string{"name", "id"}, nil,
-	)
-
-	illumination = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "illumination"),
-		"The illumination of the remo device",
-		[]string{"name", "id"}, nil,
-	)
-
-	motion = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "motion"),
-		"The motion of the remo device",
-		[]string{"name", "id"}, nil,
-	)
-
-	normalElectricEnergy = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "normal_direction_cumulative_electric_energy"),
-		"The raw value for cumulative electric energy in normal direction",
-		[]string{"name", "id"}, nil,
-	)
-
-	reverseElectricEnergy = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "reverse_direction_cumulative_electric_energy"),
-		"The raw value for cumulative electric energy in reverse direction",
-		[]string{"name", "id"}, nil,
-	)
-
-	coefficient = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "coefficient"),
-		"The coefficient for cumulative electric energy",
-		[]string{"name", "id"}, nil,
-	)
-
-	electricEnergyUnit = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "cumulative_electric_energy_unit_kilowatt_hour"),
-		"The unit in kWh for cumulative electric energy",
-		[]string{"name", "id"}, nil,
-	)
-
-	electricEnergyDigits = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "cumulative_electric_energy_effective_digits"),
-		"The number of effective digits for cumulative electric energy",
-		[]string{"name", "id"}, nil,
-	)
-
-	measuredInstantaneousEnergy = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "measured_instantaneous_energy_watt"),
-		"The measured instantaneous energy in W",
-		[]string{"name", "id"}, nil,
-	)
-
-	rateLimitLimit = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "x_rate_limit_limit"),
-		"The rate limit for the remo API",
-		nil, nil,
-	)
-
-	rateLimitReset = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "x_rate_limit_reset"),
-		"The time in which the rate limit for the remo API will be reset",
-		nil, nil,
-	)
-
-	rateLimitRemaining = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "x_rate_limit_remaining"),
-		"The remaining number of request for the remo API",
-		nil, nil,
-	)
-
-	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Namespace: namespace,
-		Name:      "http_requests_total",
-		Help:      "The total number of requests labeled by response code",
-	},
-		[]string{"code", "api"},
-	)
-)
+// AuthChecker is implemented by RemoGatherers which track whether their oauth token is valid
+type AuthChecker interface {
+	AuthValid() bool
+}
+
+// AccountGatherer is the RemoGatherer of a single Nature account
+type AccountGatherer struct {
+	Name   string
+	Client RemoGatherer
+}
 
 // Exporter collects ECS clusters metrics
 type Exporter struct {
-	client RemoGatherer // Custom ECS client to get information from the clusters
+	mu         sync.RWMutex
+	accounts   []AccountGatherer
+	filters    *config.Filters
+	labels     *config.StaticLabels
+	collectors collectorSet
+	descs      *metricDescs
+	registerer prometheus.Registerer
+	config     *config.Config
+	self       *selfMetrics
+	now        func() time.Time
+	logger     log.Logger
 }
 
-// NewExporter returns an initialized exporter
-func NewExporter(config *config.Config, client RemoGatherer) (*Exporter, error) {
-	return &Exporter{
-		client: client,
-	}, nil
+// New returns an exporter configured by the options, e.g.
+//
+//	e, err := exporter.New(exporter.WithConfig(c), exporter.WithClient(client))
+func New(opts ...ExporterOption) (*Exporter, error) {
+	e := &Exporter{
+		config: &config.Config{},
+		now:    time.Now,
+		logger: log.Default(),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.filters = e.config.Filters
+	e.labels = e.config.Labels
+	e.collectors = enabledCollectors(e.config)
+	e.descs = newMetricDescs(e.config)
+	if e.self != nil {
+		e.descs.self = e.self
+	}
+
+	if e.registerer != nil {
+		if err := e.registerer.Register(e); err != nil {
+			return nil, fmt.Errorf("failed to register the exporter: %w", err)
+		}
+	}
+	return e, nil
 }
 
-// Describe is to describe the metrics for Prometheus
+// NewExporter returns an initialized exporter for the default account.
+// It is the same as New(WithConfig(c), WithClient(client), opts...).
+func NewExporter(c *config.Config, client RemoGatherer, opts ...ExporterOption) (*Exporter, error) {
+	return New(append([]ExporterOption{WithConfig(c), WithClient(client)}, opts...)...)
+}
+
+// NewAccountsExporter returns an initialized exporter for multiple accounts.
+// It is the same as New(WithConfig(c), WithAccounts(accounts...), opts...).
+func NewAccountsExporter(c *config.Config, accounts []AccountGatherer, opts ...ExporterOption) (*Exporter, error) {
+	return New(append([]ExporterOption{WithConfig(c), WithAccounts(accounts...)}, opts...)...)
+}
+
+// ApplyConfig applies the settings of a reloaded config.
+// The label mode and the keys of the static labels can't change because they are part of the described metrics.
+func (e *Exporter) ApplyConfig(c *config.Config) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.filters = c.Filters
+	e.labels = c.Labels
+	e.collectors = enabledCollectors(c)
+}
+
+// SetAccounts replaces the accounts of the exporter, e.g. after the config was reloaded
+func (e *Exporter) SetAccounts(accounts []AccountGatherer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.accounts = accounts
+}
+
+func (e *Exporter) getAccounts() []AccountGatherer {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.accounts
+}
+
+// Describe is to describe the metrics for Prometheus.
+// The metrics of every collector group are described so groups can be enabled by a reloaded config.
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
-	ch <- temperature
-	ch <- humidity
-	ch <- illumination
-	ch <- motion
-	ch <- normalElectricEnergy
-	ch <- reverseElectricEnergy
-	ch <- coefficient
-	ch <- electricEnergyUnit
-	ch <- electricEnergyDigits
-	ch <- measuredInstantaneousEnergy
-	ch <- rateLimitLimit
-	ch <- rateLimitReset
-	ch <- rateLimitRemaining
-	httpRequestsTotal.Describe(ch)
+	e.describe(allCollectors, ch)
+}
+
+func (e *Exporter) describe(groups collectorSet, ch chan<- *prometheus.Desc) {
+	d := e.descs
+	if groups[config.CollectorSensors] {
+		d.temperature.describe(ch)
+		d.humidity.describe(ch)
+		d.illumination.describe(ch)
+		d.motion.describe(ch)
+		d.sensorValue.describe(ch)
+		d.sensorTimestamp.describe(ch)
+	}
+	if groups[config.CollectorEnergy] {
+		d.normalElectricEnergy.describe(ch)
+		d.reverseElectricEnergy.describe(ch)
+		d.coefficient.describe(ch)
+		d.electricEnergyUnit.describe(ch)
+		d.electricEnergyDigits.describe(ch)
+		d.measuredInstantaneousEnergy.describe(ch)
+	}
+	if d.withInfo && groups[config.CollectorSensors] {
+		ch <- d.deviceInfo
+	}
+	if d.withInfo && groups[config.CollectorAppliances] {
+		ch <- d.applianceInfo
+	}
+	if groups[config.CollectorRateLimit] {
+		d.rateLimitLimit.describe(ch)
+		d.rateLimitReset.describe(ch)
+		d.rateLimitRemaining.describe(ch)
+	}
+	if groups[config.CollectorSelf] {
+		d.self.httpRequestsTotal.Describe(ch)
+	}
+	if groups[config.CollectorRateLimit] {
+		ch <- d.apiRateLimitRemaining
+	}
+	if groups[config.CollectorSelf] {
+		ch <- d.cacheAge
+		ch <- d.authValid
+		ch <- d.filteredEntities
+		d.self.cacheHitsTotal.Describe(ch)
+		d.self.cacheMissesTotal.Describe(ch)
+		d.self.httpRequestDuration.Describe(ch)
+		d.self.httpResponseSize.Describe(ch)
+		d.self.apiErrorsTotal.Describe(ch)
+		d.self.apiUnknownFields.Describe(ch)
+	}
 }
 
 // Collect collects data to be consumed by prometheus
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	devices, err := e.client.GetDevices()
-	if err != nil {
-		log.Errorf("Fetching device stats failed: %v", err)
+	e.collect(context.Background(), allCollectors, ch)
+}
+
+// collect collects the metrics of the groups which are enabled as well.
+// The requests to the remo API are cancelled with ctx, e.g. when the scrape times out.
+func (e *Exporter) collect(ctx context.Context, groups collectorSet, ch chan<- prometheus.Metric) {
+	groups = groups.intersect(e.getCollectors())
+	accounts := e.getAccounts()
+	var wg sync.WaitGroup
+	for _, a := range accounts {
+		wg.Add(1)
+		go func(a AccountGatherer) {
+			defer wg.Done()
+			e.collectAccount(ctx, a, groups, ch)
+		}(a)
+	}
+	wg.Wait()
+
+	if groups[config.CollectorSelf] {
+		self := e.descs.self
+		e.collectOwnAccounts(accounts, ch,
+			self.httpRequestsTotal,
+			self.cacheHitsTotal,
+			self.cacheMissesTotal,
+			self.httpRequestDuration,
+			self.httpResponseSize,
+			self.apiErrorsTotal,
+			self.apiUnknownFields,
+		)
+	}
+}
+
+// collectOwnAccounts collects the metrics of the collectors which belong to the current accounts of this exporter.
+// The collectors keep the series of removed accounts and may be shared with other exporters, e.g. the ones created for /probe.
+func (e *Exporter) collectOwnAccounts(accounts []AccountGatherer, ch chan<- prometheus.Metric, collectors ...prometheus.Collector) {
+	own := map[string]bool{}
+	for _, a := range accounts {
+		own[a.Name] = true
+	}
+
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		for _, c := range collectors {
+			c.Collect(metrics)
+		}
+		close(metrics)
+	}()
+	for m := range metrics {
+		if own[accountOf(m)] {
+			ch <- m
+		}
+	}
+}
+
+func accountOf(m prometheus.Metric) string {
+	pb := &dto.Metric{}
+	if err := m.Write(pb); err != nil {
+		return ""
+	}
+	for _, l := range pb.GetLabel() {
+		if l.GetName() == "account" {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+// Ready returns an error if the oauth token of any account was rejected by the remo API
+func (e *Exporter) Ready() error {
+	invalid := []string{}
+	for _, a := range e.getAccounts() {
+		if checker, ok := a.Client.(AuthChecker); ok && !checker.AuthValid() {
+			invalid = append(invalid, a.Name)
+		}
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("invalid oauth token for accounts: %s", strings.Join(invalid, ", "))
+	}
+	return nil
+}
+
+// getDevices requests the devices with ctx if the client supports it
+func getDevices(ctx context.Context, client RemoGatherer) (*types.GetDevicesResult, error) {
+	if c, ok := client.(ContextGatherer); ok {
+		return c.GetDevicesContext(ctx)
+	}
+	return client.GetDevices()
+}
+
+// getAppliances requests the appliances with ctx if the client supports it
+func getAppliances(ctx context.Context, client RemoGatherer) (*types.GetAppliancesResult, error) {
+	if c, ok := client.(ContextGatherer); ok {
+		return c.GetAppliancesContext(ctx)
+	}
+	return client.GetAppliances()
+}
+
+func (e *Exporter) collectAccount(ctx context.Context, a AccountGatherer, groups collectorSet, ch chan<- prometheus.Metric) {
+	if checker, ok := a.Client.(AuthChecker); ok && groups[config.CollectorSelf] {
+		defer func() {
+			ch <- prometheus.MustNewConstMetric(e.descs.authValid, prometheus.GaugeValue, boolToFloat(checker.AuthValid()), a.Name)
+		}()
+	}
+
+	devices, err := getDevices(ctx, a.Client)
+	var apiErr *types.APIError
+	if errors.Is(err, ErrAuthBackoff) {
+		e.logger.Debugf("Skipped fetching the stats of account %s: %v", a.Name, err)
+		return
+	} else if errors.As(err, &apiErr) {
+		e.logger.Errorf("Fetching device stats of account %s failed: %v", a.Name, err)
+		e.descs.self.countAPIError(a.Name, "devices", apiErr)
+		devices = &types.GetDevicesResult{StatusCode: apiErr.StatusCode, Meta: apiErr.Meta}
+	} else if err != nil {
+		e.logger.Errorf("Fetching device stats of account %s failed: %v", a.Name, err)
 		return
 	}
 
-	appliances, err := e.client.GetAppliances()
-	if err != nil {
-		log.Errorf("Fetching appliances stats failed: %v", err)
+	// the appliances aren't requested if none of their metrics are exported
+	appliances := &types.GetAppliancesResult{}
+	err = nil
+	if groups[config.CollectorEnergy] || groups[config.CollectorAppliances] {
+		appliances, err = getAppliances(ctx, a.Client)
+	}
+	if errors.Is(err, ErrAuthBackoff) {
+		// the token was just rejected while fetching the devices
+		appliances = &types.GetAppliancesResult{}
+	} else if errors.As(err, &apiErr) {
+		e.logger.Errorf("Fetching appliances stats of account %s failed: %v", a.Name, err)
+		e.descs.self.countAPIError(a.Name, "appliances", apiErr)
+		appliances = &types.GetAppliancesResult{StatusCode: apiErr.StatusCode, Meta: apiErr.Meta}
+	} else if err != nil {
+		e.logger.Errorf("Fetching appliances stats of account %s failed: %v", a.Name, err)
 		return
 	}
 
-	err = e.processMetrics(devices, appliances, ch)
+	err = e.processMetrics(a.Name, devices, appliances, groups, ch)
 	if err != nil {
-		log.Errorf("Processing the metrics failed: %v", err)
+		e.logger.Errorf("Processing the metrics of account %s failed: %v", a.Name, err)
 		return
 	}
-
 }
 
-func (e *Exporter) processMetrics(devicesResult *types.GetDevicesResult, appliancesResult *types.GetAppliancesResult, ch chan<- prometheus.Metric) error {
-	for _, d := range devicesResult.Devices {
-		if d.NewestEvents == nil {
+// unknownSensors returns the sorted keys of the sensors which have no dedicated metric
+func unknownSensors(events types.Event) []string {
+	keys := []string{}
+	for key, v := range events {
+		if v == nil {
 			continue
 		}
-		if d.NewestEvents.Temperature != nil {
-			ch <- prometheus.MustNewConstMetric(temperature, prometheus.GaugeValue, d.NewestEvents.Temperature.Value, d.Name, d.ID)
-		}
-		if d.NewestEvents.Humidity != nil {
-			ch <- prometheus.MustNewConstMetric(humidity, prometheus.GaugeValue, d.NewestEvents.Humidity.Value, d.Name, d.ID)
-		}
-		if d.NewestEvents.Illumination != nil {
-			ch <- prometheus.MustNewConstMetric(illumination, prometheus.GaugeValue, d.NewestEvents.Illumination.Value, d.Name, d.ID)
+		switch key {
+		case types.SensorTemperature, types.SensorHumidity, types.SensorIllumination, types.SensorMotion:
+			continue
 		}
-		if d.NewestEvents.Motion != nil {
-			ch <- prometheus.MustNewConstMetric(motion, prometheus.GaugeValue, float64(d.NewestEvents.Motion.CreatedAt.Unix()), d.Name, d.ID)
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (e *Exporter) processMetrics(account string, devicesResult *types.GetDevicesResult, appliancesResult *types.GetAppliancesResult, groups collectorSet, ch chan<- prometheus.Metric) error {
+	e.mu.RLock()
+	filters := e.filters
+	labels := e.labels
+	e.mu.RUnlock()
+
+	devices, filteredDevices := filterDevices(filters, devicesResult.Devices)
+	appliances, filteredAppliances := filterAppliances(filters, appliancesResult.Appliances)
+	if filters != nil && groups[config.CollectorSelf] {
+		ch <- prometheus.MustNewConstMetric(e.descs.filteredEntities, prometheus.GaugeValue, float64(filteredDevices), account, entityDevice)
+		ch <- prometheus.MustNewConstMetric(e.descs.filteredEntities, prometheus.GaugeValue, float64(filteredAppliances), account, entityAppliance)
+	}
+
+	if e.descs.withInfo {
+		e.collectInfo(account, devices, appliances, groups, ch)
+	}
+
+	if groups[config.CollectorSensors] {
+		for _, d := range devices {
+			if d.NewestEvents == nil {
+				continue
+			}
+			deviceLabels := labels.DeviceValues(d.ID)
+			deviceLabelValues := e.descs.labelValues(deviceLabels, account, d.Name, d.ID)
+			if v := d.NewestEvents[types.SensorTemperature]; v != nil {
+				e.descs.temperature.emit(ch, v.Value, v.Value, deviceLabelValues...)
+			}
+			if v := d.NewestEvents[types.SensorHumidity]; v != nil {
+				e.descs.humidity.emit(ch, v.Value, v.Value/100, deviceLabelValues...)
+			}
+			if v := d.NewestEvents[types.SensorIllumination]; v != nil {
+				e.descs.illumination.emit(ch, v.Value, v.Value, deviceLabelValues...)
+			}
+			if v := d.NewestEvents[types.SensorMotion]; v != nil {
+				createdAt := float64(v.CreatedAt.Unix())
+				e.descs.motion.emit(ch, createdAt, createdAt, deviceLabelValues...)
+			}
+			for _, key := range unknownSensors(d.NewestEvents) {
+				v := d.NewestEvents[key]
+				createdAt := float64(v.CreatedAt.Unix())
+				sensorLabelValues := e.descs.labelValues(deviceLabels, account, d.Name, d.ID, key)
+				e.descs.sensorValue.emit(ch, v.Value, v.Value, sensorLabelValues...)
+				e.descs.sensorTimestamp.emit(ch, createdAt, createdAt, sensorLabelValues...)
+			}
 		}
 	}
 
-	sms := getSmartMeters(appliancesResult.Appliances)
-	for _, sm := range sms {
-		info, err := energyInfo(sm)
-		if err != nil {
-			log.Errorf("failed to get EnergyInfo: %v", err)
-			continue
+	if groups[config.CollectorEnergy] {
+		sms := getSmartMeters(appliances)
+		for _, sm := range sms {
+			info, err := energyInfo(sm)
+			if err != nil {
+				e.logger.Errorf("failed to get EnergyInfo: %v", err)
+				continue
+			}
+			smLabels := e.descs.labelValues(labels.ApplianceValues(sm.ID, sm.Device.ID), account, sm.Device.Name, sm.Device.ID)
+			e.descs.normalElectricEnergy.emit(ch, float64(info.NormalEnergy), info.kilowattHours(info.NormalEnergy), smLabels...)
+			e.descs.reverseElectricEnergy.emit(ch, float64(info.ReverseEnergy), info.kilowattHours(info.ReverseEnergy), smLabels...)
+			e.descs.coefficient.emit(ch, float64(info.Coefficient), float64(info.Coefficient), smLabels...)
+			e.descs.electricEnergyUnit.emit(ch, info.EnergyUnit, info.EnergyUnit, smLabels...)
+			e.descs.electricEnergyDigits.emit(ch, float64(info.EffectiveDigits), float64(info.EffectiveDigits), smLabels...)
+			e.descs.measuredInstantaneousEnergy.emit(ch, float64(info.MeasuredInstantaneous), float64(info.MeasuredInstantaneous), smLabels...)
 		}
-		ch <- prometheus.MustNewConstMetric(normalElectricEnergy, prometheus.CounterValue, float64(info.NormalEnergy), sm.Device.Name, sm.Device.ID)
-		ch <- prometheus.MustNewConstMetric(reverseElectricEnergy, prometheus.CounterValue, float64(info.ReverseEnergy), sm.Device.Name, sm.Device.ID)
-		ch <- prometheus.MustNewConstMetric(coefficient, prometheus.GaugeValue, float64(info.Coefficient), sm.Device.Name, sm.Device.ID)
-		ch <- prometheus.MustNewConstMetric(electricEnergyUnit, prometheus.GaugeValue, info.EnergyUnit, sm.Device.Name, sm.Device.ID)
-		ch <- prometheus.MustNewConstMetric(electricEnergyDigits, prometheus.GaugeValue, float64(info.EffectiveDigits), sm.Device.Name, sm.Device.ID)
-		ch <- prometheus.MustNewConstMetric(measuredInstantaneousEnergy, prometheus.GaugeValue, float64(info.MeasuredInstantaneous), sm.Device.Name, sm.Device.ID)
 	}
 
-	if appliancesResult.Meta != nil {
-		ch <- prometheus.MustNewConstMetric(rateLimitLimit, prometheus.GaugeValue, appliancesResult.Meta.RateLimitLimit)
-		ch <- prometheus.MustNewConstMetric(rateLimitRemaining, prometheus.GaugeValue, appliancesResult.Meta.RateLimitRemaining)
-		ch <- prometheus.MustNewConstMetric(rateLimitReset, prometheus.GaugeValue, appliancesResult.Meta.RateLimitReset)
-	} else if devicesResult.Meta != nil {
-		ch <- prometheus.MustNewConstMetric(rateLimitLimit, prometheus.GaugeValue, devicesResult.Meta.RateLimitLimit)
-		ch <- prometheus.MustNewConstMetric(rateLimitRemaining, prometheus.GaugeValue, devicesResult.Meta.RateLimitRemaining)
-		ch <- prometheus.MustNewConstMetric(rateLimitReset, prometheus.GaugeValue, devicesResult.Meta.RateLimitReset)
+	meta := appliancesResult.Meta
+	if meta == nil {
+		meta = devicesResult.Meta
+	}
+	if meta != nil && groups[config.CollectorRateLimit] {
+		e.descs.rateLimitLimit.emit(ch, meta.RateLimitLimit, meta.RateLimitLimit, account)
+		e.descs.rateLimitRemaining.emit(ch, meta.RateLimitRemaining, meta.RateLimitRemaining, account)
+		e.descs.rateLimitReset.emit(ch, meta.RateLimitReset, meta.RateLimitReset, account)
 	}
 
 	if devicesResult.StatusCode > 0 {
 		if !devicesResult.IsCache {
 			// increment the counter only if it's not a cache
-			httpRequestsTotal.WithLabelValues(strconv.Itoa(devicesResult.StatusCode), "devices").Inc()
+			e.descs.self.httpRequestsTotal.WithLabelValues(account, strconv.Itoa(devicesResult.StatusCode), "devices").Inc()
 		}
 	}
 	if appliancesResult.StatusCode > 0 {
 		if !appliancesResult.IsCache {
 			// increment the counter only if it's not a cache
-			httpRequestsTotal.WithLabelValues(strconv.Itoa(appliancesResult.StatusCode), "appliances").Inc()
+			e.descs.self.httpRequestsTotal.WithLabelValues(account, strconv.Itoa(appliancesResult.StatusCode), "appliances").Inc()
 		}
 	}
-	httpRequestsTotal.Collect(ch)
+	stats := []apiStats{
+		{
+			api:          "devices",
+			statusCode:   devicesResult.StatusCode,
+			isCache:      devicesResult.IsCache,
+			meta:         devicesResult.Meta,
+			fetchedAt:    devicesResult.FetchedAt,
+			duration:     devicesResult.Duration,
+			responseSize: devicesResult.ResponseSize,
+		},
+		{
+			api:          "appliances",
+			statusCode:   appliancesResult.StatusCode,
+			isCache:      appliancesResult.IsCache,
+			meta:         appliancesResult.Meta,
+			fetchedAt:    appliancesResult.FetchedAt,
+			duration:     appliancesResult.Duration,
+			responseSize: appliancesResult.ResponseSize,
+		},
+	}
+	for _, s := range stats {
+		e.processAPIStats(account, s, groups, ch)
+	}
+
+	for _, f := range devicesResult.UnknownFields {
+		e.descs.self.apiUnknownFields.WithLabelValues(account, f.Object, f.Field).Set(1)
+	}
+	for _, f := range appliancesResult.UnknownFields {
+		e.descs.self.apiUnknownFields.WithLabelValues(account, f.Object, f.Field).Set(1)
+	}
 
 	return nil
 }
+
+// collectInfo exports the names of the devices and appliances which aren't labels of their series in LabelModeID
+func (e *Exporter) collectInfo(account string, devices []*types.Device, appliances []*types.Appliance, groups collectorSet, ch chan<- prometheus.Metric) {
+	if groups[config.CollectorSensors] {
+		for _, d := range devices {
+			ch <- prometheus.MustNewConstMetric(e.descs.deviceInfo, prometheus.GaugeValue, 1, account, d.ID, d.Name, d.FirmwareVersion)
+		}
+	}
+	if !groups[config.CollectorAppliances] {
+		return
+	}
+	for _, app := range appliances {
+		deviceID := ""
+		if app.Device != nil {
+			deviceID = app.Device.ID
+		}
+		ch <- prometheus.MustNewConstMetric(e.descs.applianceInfo, prometheus.GaugeValue, 1, account, app.ID, app.Nickname, app.Type, deviceID)
+	}
+}
+
+func (e *Exporter) processAPIStats(account string, s apiStats, groups collectorSet, ch chan<- prometheus.Metric) {
+	if s.statusCode == 0 {
+		// the api was not requested at all
+		return
+	}
+
+	if s.isCache {
+		e.descs.self.cacheHitsTotal.WithLabelValues(account, s.api).Inc()
+	} else {
+		e.descs.self.cacheMissesTotal.WithLabelValues(account, s.api).Inc()
+		if s.duration > 0 {
+			e.descs.self.httpRequestDuration.WithLabelValues(account, s.api).Observe(s.duration.Seconds())
+		}
+		if s.statusCode == 200 {
+			e.descs.self.httpResponseSize.WithLabelValues(account, s.api).Observe(float64(s.responseSize))
+		}
+	}
+
+	if s.meta != nil && groups[config.CollectorRateLimit] {
+		ch <- prometheus.MustNewConstMetric(e.descs.apiRateLimitRemaining, prometheus.GaugeValue, s.meta.RateLimitRemaining, account, s.api)
+	}
+	if !s.fetchedAt.IsZero() && groups[config.CollectorSelf] {
+		ch <- prometheus.MustNewConstMetric(e.descs.cacheAge, prometheus.GaugeValue, e.now().Sub(s.fetchedAt).Seconds(), account, s.api)
+	}
+}
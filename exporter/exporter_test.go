@@ -1,6 +1,10 @@
 package exporter_test
 
 import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"regexp"
 	"strconv"
 	"time"
 
@@ -8,6 +12,7 @@ import (
 	"github.com/kenfdev/remo-exporter/config"
 	"github.com/kenfdev/remo-exporter/types"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
@@ -49,6 +54,18 @@ func readCounter(g prometheus.Metric) metricResult {
 	}
 }
 
+var fqNameRe = regexp.MustCompile(`fqName: "([^"]+)"`)
+
+// collectByName drains the channel and groups the metrics by their fully qualified name
+func collectByName(ch <-chan prometheus.Metric) map[string][]prometheus.Metric {
+	res := map[string][]prometheus.Metric{}
+	for m := range ch {
+		name := fqNameRe.FindStringSubmatch(m.Desc().String())[1]
+		res[name] = append(res[name], m)
+	}
+	return res
+}
+
 var _ = Describe("Exporter", func() {
 	var (
 		mockCtrl   *gomock.Controller
@@ -73,33 +90,57 @@ var _ = Describe("Exporter", func() {
 			go e.Describe(ch)
 
 			d := (<-ch)
-			Expect(d.String()).To(Equal(`Desc{fqName: "remo_temperature", help: "The temperature of the remo device", constLabels: {}, variableLabels: [name id]}`))
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_temperature", help: "The temperature of the remo device", constLabels: {}, variableLabels: {account,name,id}}`))
+			d = (<-ch)
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_humidity", help: "The humidity of the remo device", constLabels: {}, variableLabels: {account,name,id}}`))
+			d = (<-ch)
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_illumination", help: "The illumination of the remo device", constLabels: {}, variableLabels: {account,name,id}}`))
+			d = (<-ch)
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_motion", help: "The motion of the remo device", constLabels: {}, variableLabels: {account,name,id}}`))
+			d = (<-ch)
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_sensor_value", help: "The value of a sensor of the remo device which has no dedicated metric", constLabels: {}, variableLabels: {account,name,id,sensor}}`))
+			d = (<-ch)
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_sensor_timestamp_seconds", help: "The time the value of a sensor of the remo device which has no dedicated metric was created", constLabels: {}, variableLabels: {account,name,id,sensor}}`))
+			d = (<-ch)
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_normal_direction_cumulative_electric_energy", help: "The raw value for cumulative electric energy in normal direction", constLabels: {}, variableLabels: {account,name,id}}`))
+			d = (<-ch)
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_reverse_direction_cumulative_electric_energy", help: "The raw value for cumulative electric energy in reverse direction", constLabels: {}, variableLabels: {account,name,id}}`))
+			d = (<-ch)
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_coefficient", help: "The coefficient for cumulative electric energy", constLabels: {}, variableLabels: {account,name,id}}`))
+			d = (<-ch)
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_cumulative_electric_energy_unit_kilowatt_hour", help: "The unit in kWh for cumulative electric energy", constLabels: {}, variableLabels: {account,name,id}}`))
 			d = (<-ch)
-			Expect(d.String()).To(Equal(`Desc{fqName: "remo_humidity", help: "The humidity of the remo device", constLabels: {}, variableLabels: [name id]}`))
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_cumulative_electric_energy_effective_digits", help: "The number of effective digits for cumulative electric energy", constLabels: {}, variableLabels: {account,name,id}}`))
 			d = (<-ch)
-			Expect(d.String()).To(Equal(`Desc{fqName: "remo_illumination", help: "The illumination of the remo device", constLabels: {}, variableLabels: [name id]}`))
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_measured_instantaneous_energy_watt", help: "The measured instantaneous energy in W", constLabels: {}, variableLabels: {account,name,id}}`))
 			d = (<-ch)
-			Expect(d.String()).To(Equal(`Desc{fqName: "remo_motion", help: "The motion of the remo device", constLabels: {}, variableLabels: [name id]}`))
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_x_rate_limit_limit", help: "The rate limit for the remo API", constLabels: {}, variableLabels: {account}}`))
 			d = (<-ch)
-			Expect(d.String()).To(Equal(`Desc{fqName: "remo_normal_direction_cumulative_electric_energy", help: "The raw value for cumulative electric energy in normal direction", constLabels: {}, variableLabels: [name id]}`))
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_x_rate_limit_reset", help: "The time in which the rate limit for the remo API will be reset", constLabels: {}, variableLabels: {account}}`))
 			d = (<-ch)
-			Expect(d.String()).To(Equal(`Desc{fqName: "remo_reverse_direction_cumulative_electric_energy", help: "The raw value for cumulative electric energy in reverse direction", constLabels: {}, variableLabels: [name id]}`))
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_x_rate_limit_remaining", help: "The remaining number of request for the remo API", constLabels: {}, variableLabels: {account}}`))
 			d = (<-ch)
-			Expect(d.String()).To(Equal(`Desc{fqName: "remo_coefficient", help: "The coefficient for cumulative electric energy", constLabels: {}, variableLabels: [name id]}`))
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_http_requests_total", help: "The total number of requests labeled by response code", constLabels: {}, variableLabels: {account,code,api}}`))
 			d = (<-ch)
-			Expect(d.String()).To(Equal(`Desc{fqName: "remo_cumulative_electric_energy_unit_kilowatt_hour", help: "The unit in kWh for cumulative electric energy", constLabels: {}, variableLabels: [name id]}`))
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_api_rate_limit_remaining", help: "The remaining number of request for the remo API labeled by api", constLabels: {}, variableLabels: {account,api}}`))
 			d = (<-ch)
-			Expect(d.String()).To(Equal(`Desc{fqName: "remo_cumulative_electric_energy_effective_digits", help: "The number of effective digits for cumulative electric energy", constLabels: {}, variableLabels: [name id]}`))
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_cache_age_seconds", help: "The number of seconds since the cached response was fetched labeled by api", constLabels: {}, variableLabels: {account,api}}`))
 			d = (<-ch)
-			Expect(d.String()).To(Equal(`Desc{fqName: "remo_measured_instantaneous_energy_watt", help: "The measured instantaneous energy in W", constLabels: {}, variableLabels: [name id]}`))
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_auth_valid", help: "Whether the oauth token was accepted by the remo API on the last request", constLabels: {}, variableLabels: {account}}`))
 			d = (<-ch)
-			Expect(d.String()).To(Equal(`Desc{fqName: "remo_x_rate_limit_limit", help: "The rate limit for the remo API", constLabels: {}, variableLabels: []}`))
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_filtered_entities", help: "The number of devices or appliances not exported because of the filters labeled by kind", constLabels: {}, variableLabels: {account,kind}}`))
 			d = (<-ch)
-			Expect(d.String()).To(Equal(`Desc{fqName: "remo_x_rate_limit_reset", help: "The time in which the rate limit for the remo API will be reset", constLabels: {}, variableLabels: []}`))
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_cache_hits_total", help: "The total number of results served from the cache labeled by api", constLabels: {}, variableLabels: {account,api}}`))
 			d = (<-ch)
-			Expect(d.String()).To(Equal(`Desc{fqName: "remo_x_rate_limit_remaining", help: "The remaining number of request for the remo API", constLabels: {}, variableLabels: []}`))
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_cache_misses_total", help: "The total number of results fetched from the remo API labeled by api", constLabels: {}, variableLabels: {account,api}}`))
 			d = (<-ch)
-			Expect(d.String()).To(Equal(`Desc{fqName: "remo_http_requests_total", help: "The total number of requests labeled by response code", constLabels: {}, variableLabels: [code api]}`))
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_http_request_duration_seconds", help: "The latency of requests to the remo API labeled by api", constLabels: {}, variableLabels: {account,api}}`))
+			d = (<-ch)
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_http_response_size_bytes", help: "The size of successful responses from the remo API labeled by api", constLabels: {}, variableLabels: {account,api}}`))
+			d = (<-ch)
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_api_errors_total", help: "The total number of error responses from the remo API labeled by api, status and error code", constLabels: {}, variableLabels: {account,api,status,code}}`))
+			d = (<-ch)
+			Expect(d.String()).To(Equal(`Desc{fqName: "remo_api_unknown_fields", help: "Fields in the remo API responses which are unknown to the exporter labeled by object and field", constLabels: {}, variableLabels: {account,object,field}}`))
 		})
 	})
 
@@ -111,20 +152,24 @@ var _ = Describe("Exporter", func() {
 				Name:            "some_device_name",
 				ID:              "some_device_id",
 				FirmwareVersion: "Remo/1.0.77-g808448c",
-				NewestEvents: &types.Event{
-					Temperature: &types.SensorValue{
+				NewestEvents: types.Event{
+					types.SensorTemperature: &types.SensorValue{
 						Value: 50.0,
 					},
-					Humidity: &types.SensorValue{
+					types.SensorHumidity: &types.SensorValue{
 						Value: 60.0,
 					},
-					Illumination: &types.SensorValue{
+					types.SensorIllumination: &types.SensorValue{
 						Value: 40.0,
 					},
-					Motion: &types.SensorValue{
+					types.SensorMotion: &types.SensorValue{
 						CreatedAt: time.Now(),
 						Value:     1.0,
 					},
+					"co": &types.SensorValue{
+						CreatedAt: time.Unix(1532778912, 0),
+						Value:     800.0,
+					},
 				},
 			}
 			result := &types.GetDevicesResult{
@@ -135,7 +180,8 @@ var _ = Describe("Exporter", func() {
 					RateLimitRemaining: 29.0,
 					RateLimitReset:     1532778912,
 				},
-				IsCache: false,
+				IsCache:  false,
+				Duration: 100 * time.Millisecond,
 			}
 			remoClient.EXPECT().GetDevices().Return(result, nil)
 			remoClient.EXPECT().GetAppliances().Return(&types.GetAppliancesResult{}, nil)
@@ -145,34 +191,48 @@ var _ = Describe("Exporter", func() {
 			Expect(err).Should(BeNil())
 
 			ch := make(chan prometheus.Metric)
-			defer close(ch)
 
-			go e.Collect(ch)
+			go func() {
+				e.Collect(ch)
+				close(ch)
+			}()
 
 			m := (<-ch).(prometheus.Metric)
 			m2 := readGauge(m)
-			Expect(m2.value).To(Equal(device.NewestEvents.Temperature.Value))
+			Expect(m2.value).To(Equal(device.NewestEvents[types.SensorTemperature].Value))
 			Expect(m2.labels["name"]).To(Equal(device.Name))
 			Expect(m2.labels["id"]).To(Equal(device.ID))
 
 			m = (<-ch).(prometheus.Metric)
 			m2 = readGauge(m)
-			Expect(m2.value).To(Equal(device.NewestEvents.Humidity.Value))
+			Expect(m2.value).To(Equal(device.NewestEvents[types.SensorHumidity].Value))
 			Expect(m2.labels["name"]).To(Equal(device.Name))
 			Expect(m2.labels["id"]).To(Equal(device.ID))
 
 			m = (<-ch).(prometheus.Metric)
 			m2 = readGauge(m)
-			Expect(m2.value).To(Equal(device.NewestEvents.Illumination.Value))
+			Expect(m2.value).To(Equal(device.NewestEvents[types.SensorIllumination].Value))
 			Expect(m2.labels["name"]).To(Equal(device.Name))
 			Expect(m2.labels["id"]).To(Equal(device.ID))
 
 			m = (<-ch).(prometheus.Metric)
 			m2 = readGauge(m)
-			Expect(m2.value).To(Equal(float64(device.NewestEvents.Motion.CreatedAt.Unix())))
+			Expect(m2.value).To(Equal(float64(device.NewestEvents[types.SensorMotion].CreatedAt.Unix())))
 			Expect(m2.labels["name"]).To(Equal(device.Name))
 			Expect(m2.labels["id"]).To(Equal(device.ID))
 
+			m = (<-ch).(prometheus.Metric)
+			m2 = readGauge(m)
+			Expect(m2.value).To(Equal(device.NewestEvents["co"].Value))
+			Expect(m2.labels["sensor"]).To(Equal("co"))
+			Expect(m2.labels["id"]).To(Equal(device.ID))
+
+			m = (<-ch).(prometheus.Metric)
+			m2 = readGauge(m)
+			Expect(m2.value).To(BeNumerically("==", 1532778912))
+			Expect(m2.labels["sensor"]).To(Equal("co"))
+			Expect(m2.labels["id"]).To(Equal(device.ID))
+
 			m = (<-ch).(prometheus.Metric)
 			m2 = readGauge(m)
 			Expect(m2.value).To(Equal(result.Meta.RateLimitLimit))
@@ -183,9 +243,87 @@ var _ = Describe("Exporter", func() {
 			m2 = readGauge(m)
 			Expect(m2.value).To(Equal(result.Meta.RateLimitReset))
 
-			counter := (<-ch).(prometheus.Counter)
-			m2 = readCounter(counter)
+			rest := collectByName(ch)
+			Expect(rest["remo_http_requests_total"]).NotTo(BeEmpty())
+			m2 = readCounter(rest["remo_http_requests_total"][0])
 			Expect(m2.labels["code"]).To(Equal(strconv.Itoa(result.StatusCode)))
+			Expect(m2.labels["account"]).To(Equal(config.DefaultAccountName))
+
+			Expect(rest["remo_api_rate_limit_remaining"]).To(HaveLen(1))
+			m2 = readGauge(rest["remo_api_rate_limit_remaining"][0])
+			Expect(m2.value).To(Equal(result.Meta.RateLimitRemaining))
+			Expect(m2.labels["api"]).To(Equal("devices"))
+			Expect(rest).NotTo(HaveKey("remo_cache_age_seconds"))
+			Expect(rest).To(HaveKey("remo_cache_misses_total"))
+			Expect(rest).To(HaveKey("remo_http_request_duration_seconds"))
+			Expect(rest).To(HaveKey("remo_http_response_size_bytes"))
+		})
+
+		It("should compute the cache age with the clock", func() {
+			now := time.Unix(1600000000, 0)
+			remoClient := mocks.NewMockRemoGatherer(mockCtrl)
+			remoClient.EXPECT().GetDevices().Return(&types.GetDevicesResult{
+				StatusCode: 200,
+				IsCache:    true,
+				FetchedAt:  now.Add(-30 * time.Second),
+			}, nil)
+			remoClient.EXPECT().GetAppliances().Return(&types.GetAppliancesResult{}, nil)
+
+			e, err := New(WithClient(remoClient), WithClock(func() time.Time { return now }))
+			Expect(err).Should(BeNil())
+
+			ch := make(chan prometheus.Metric)
+			go func() {
+				e.Collect(ch)
+				close(ch)
+			}()
+
+			rest := collectByName(ch)
+			Expect(rest["remo_cache_age_seconds"]).To(HaveLen(1))
+			Expect(readGauge(rest["remo_cache_age_seconds"][0]).value).To(Equal(30.0))
+		})
+
+		It("should collect cache metrics for cached results", func() {
+			remoClient := mocks.NewMockRemoGatherer(mockCtrl)
+
+			devResult := &types.GetDevicesResult{
+				StatusCode: 200,
+				Devices:    []*types.Device{},
+				IsCache:    true,
+				FetchedAt:  time.Now().Add(-30 * time.Second),
+				UnknownFields: []types.UnknownField{
+					{Object: "device", Field: "mac_address"},
+				},
+			}
+			remoClient.EXPECT().GetDevices().Return(devResult, nil)
+			remoClient.EXPECT().GetAppliances().Return(&types.GetAppliancesResult{}, nil)
+
+			c, _ := config.NewConfig(mockReader)
+			e, err := NewExporter(c, remoClient)
+			Expect(err).Should(BeNil())
+
+			ch := make(chan prometheus.Metric)
+
+			go func() {
+				e.Collect(ch)
+				close(ch)
+			}()
+
+			rest := collectByName(ch)
+			Expect(rest["remo_cache_age_seconds"]).To(HaveLen(1))
+			m := readGauge(rest["remo_cache_age_seconds"][0])
+			Expect(m.value).To(BeNumerically(">=", 30))
+			Expect(m.labels["api"]).To(Equal("devices"))
+
+			Expect(rest["remo_cache_hits_total"]).To(HaveLen(1))
+			m = readCounter(rest["remo_cache_hits_total"][0])
+			Expect(m.labels["api"]).To(Equal("devices"))
+			Expect(m.value).To(BeNumerically(">=", 1))
+
+			Expect(rest["remo_api_unknown_fields"]).To(HaveLen(1))
+			m = readGauge(rest["remo_api_unknown_fields"][0])
+			Expect(m.labels["object"]).To(Equal("device"))
+			Expect(m.labels["field"]).To(Equal("mac_address"))
 		})
 
 		It("should collect metrics from Remo E lite", func() {
@@ -266,9 +404,11 @@ var _ = Describe("Exporter", func() {
 			Expect(err).Should(BeNil())
 
 			ch := make(chan prometheus.Metric)
-			defer close(ch)
 
-			go e.Collect(ch)
+			go func() {
+				e.Collect(ch)
+				close(ch)
+			}()
 
 			m := (<-ch).(prometheus.Metric)
 			m2 := readCounter(m)
@@ -316,13 +456,506 @@ var _ = Describe("Exporter", func() {
 			m2 = readGauge(m)
 			Expect(m2.value).To(Equal(appResult.Meta.RateLimitReset))
 
-			counter := (<-ch).(prometheus.Counter)
-			m2 = readCounter(counter)
-			Expect(m2.labels["code"]).To(Equal(strconv.Itoa(devResult.StatusCode)))
+			rest := collectByName(ch)
+			apis := []string{}
+			for _, m := range rest["remo_http_requests_total"] {
+				r := readCounter(m)
+				Expect(r.labels["code"]).To(Equal(strconv.Itoa(devResult.StatusCode)))
+				apis = append(apis, r.labels["api"])
+			}
+			Expect(apis).To(ConsistOf("devices", "appliances"))
+
+			Expect(rest["remo_api_rate_limit_remaining"]).To(HaveLen(2))
+			m2 = readGauge(rest["remo_api_rate_limit_remaining"][0])
+			Expect(m2.value).To(Equal(devResult.Meta.RateLimitRemaining))
+			Expect(m2.labels["api"]).To(Equal("devices"))
+			m2 = readGauge(rest["remo_api_rate_limit_remaining"][1])
+			Expect(m2.value).To(Equal(appResult.Meta.RateLimitRemaining))
+			Expect(m2.labels["api"]).To(Equal("appliances"))
+		})
+
+		It("should collect metrics from API errors", func() {
+			remoClient := mocks.NewMockRemoGatherer(mockCtrl)
+
+			apiErr := &types.APIError{
+				StatusCode: 401,
+				Code:       401001,
+				Message:    "Unauthorized",
+				Meta: &types.Meta{
+					RateLimitLimit:     30.0,
+					RateLimitRemaining: 27.0,
+					RateLimitReset:     1532778912,
+				},
+			}
+			remoClient.EXPECT().GetDevices().Return(nil, apiErr)
+			remoClient.EXPECT().GetAppliances().Return(nil, apiErr)
+
+			c, _ := config.NewConfig(mockReader)
+			e, err := NewExporter(c, remoClient)
+			Expect(err).Should(BeNil())
+
+			ch := make(chan prometheus.Metric)
+
+			go func() {
+				e.Collect(ch)
+				close(ch)
+			}()
+
+			rest := collectByName(ch)
+			Expect(rest["remo_x_rate_limit_remaining"]).To(HaveLen(1))
+			Expect(readGauge(rest["remo_x_rate_limit_remaining"][0]).value).To(Equal(apiErr.Meta.RateLimitRemaining))
+
+			codes := []string{}
+			for _, m := range rest["remo_http_requests_total"] {
+				codes = append(codes, readCounter(m).labels["code"])
+			}
+			Expect(codes).To(ContainElement("401"))
+
+			errs := map[string]metricResult{}
+			for _, m := range rest["remo_api_errors_total"] {
+				r := readCounter(m)
+				errs[r.labels["api"]] = r
+			}
+			Expect(errs).To(HaveKey("devices"))
+			Expect(errs).To(HaveKey("appliances"))
+			Expect(errs["devices"].labels["status"]).To(Equal("401"))
+			Expect(errs["devices"].labels["code"]).To(Equal("401001"))
+			Expect(errs["devices"].value).To(BeNumerically("==", 1))
+		})
 
-			counter = (<-ch).(prometheus.Counter)
-			m2 = readCounter(counter)
-			Expect(m2.labels["code"]).To(Equal(strconv.Itoa(appResult.StatusCode)))
+		It("should label the metrics of each account", func() {
+			homeClient := mocks.NewMockRemoGatherer(mockCtrl)
+			officeClient := mocks.NewMockRemoGatherer(mockCtrl)
+
+			newResult := func(name string, temperature float64) *types.GetDevicesResult {
+				return &types.GetDevicesResult{
+					StatusCode: 200,
+					Devices: []*types.Device{
+						{
+							Name: name,
+							ID:   name + "_id",
+							NewestEvents: types.Event{
+								types.SensorTemperature: &types.SensorValue{Value: temperature},
+							},
+						},
+					},
+				}
+			}
+			homeClient.EXPECT().GetDevices().Return(newResult("home_remo", 20.0), nil)
+			homeClient.EXPECT().GetAppliances().Return(&types.GetAppliancesResult{}, nil)
+			officeClient.EXPECT().GetDevices().Return(newResult("office_remo", 25.0), nil)
+			officeClient.EXPECT().GetAppliances().Return(&types.GetAppliancesResult{}, nil)
+
+			c, _ := config.NewConfig(mockReader)
+			e, err := NewAccountsExporter(c, []AccountGatherer{
+				{Name: "home", Client: homeClient},
+				{Name: "office", Client: officeClient},
+			})
+			Expect(err).Should(BeNil())
+
+			ch := make(chan prometheus.Metric)
+
+			go func() {
+				e.Collect(ch)
+				close(ch)
+			}()
+
+			rest := collectByName(ch)
+			temperatures := map[string]metricResult{}
+			for _, m := range rest["remo_temperature"] {
+				r := readGauge(m)
+				temperatures[r.labels["account"]] = r
+			}
+			Expect(temperatures).To(HaveLen(2))
+			Expect(temperatures["home"].value).To(Equal(20.0))
+			Expect(temperatures["home"].labels["name"]).To(Equal("home_remo"))
+			Expect(temperatures["office"].value).To(Equal(25.0))
+			Expect(temperatures["office"].labels["name"]).To(Equal("office_remo"))
+		})
+
+		It("should not export filtered devices and appliances", func() {
+			remoClient := mocks.NewMockRemoGatherer(mockCtrl)
+
+			newDevice := func(name string, id string) *types.Device {
+				return &types.Device{
+					Name: name,
+					ID:   id,
+					NewestEvents: types.Event{
+						types.SensorTemperature: &types.SensorValue{Value: 20.0},
+					},
+				}
+			}
+			remoClient.EXPECT().GetDevices().Return(&types.GetDevicesResult{
+				StatusCode: 200,
+				Devices: []*types.Device{
+					newDevice("Living", "living_id"),
+					newDevice("Test Remo", "test_id"),
+					newDevice("Neighbor", "neighbor_id"),
+				},
+			}, nil)
+			remoClient.EXPECT().GetAppliances().Return(&types.GetAppliancesResult{
+				StatusCode: 200,
+				Appliances: []*types.Appliance{
+					{Type: "EL_SMART_METER", Device: newDevice("Test Remo", "test_id"), SmartMeter: &types.SmartMeter{}},
+					{Type: "AC", Nickname: "Aircon", Device: newDevice("Living", "living_id")},
+				},
+			}, nil)
+
+			c, _ := config.NewConfig(mockReader)
+			c.Filters = &config.Filters{
+				Exclude: []*config.FilterRule{
+					{DeviceName: regexp.MustCompile("(?i)test")},
+					{DeviceID: "neighbor_id"},
+				},
+			}
+			e, err := NewAccountsExporter(c, []AccountGatherer{{Name: "filtered", Client: remoClient}})
+			Expect(err).Should(BeNil())
+
+			ch := make(chan prometheus.Metric)
+
+			go func() {
+				e.Collect(ch)
+				close(ch)
+			}()
+
+			rest := collectByName(ch)
+			Expect(rest["remo_temperature"]).To(HaveLen(1))
+			Expect(readGauge(rest["remo_temperature"][0]).labels["name"]).To(Equal("Living"))
+			Expect(rest["remo_measured_instantaneous_energy_watt"]).To(BeEmpty())
+
+			filtered := map[string]float64{}
+			for _, m := range rest["remo_filtered_entities"] {
+				r := readGauge(m)
+				filtered[r.labels["kind"]] = r.value
+			}
+			Expect(filtered).To(Equal(map[string]float64{"device": 2, "appliance": 1}))
+		})
+
+		It("should add the static labels to the metrics of the devices and appliances", func() {
+			remoClient := mocks.NewMockRemoGatherer(mockCtrl)
+
+			living := &types.Device{
+				Name: "Living",
+				ID:   "living_id",
+				NewestEvents: types.Event{
+					types.SensorTemperature: &types.SensorValue{Value: 20.0},
+				},
+			}
+			remoClient.EXPECT().GetDevices().Return(&types.GetDevicesResult{
+				StatusCode: 200,
+				Devices: []*types.Device{
+					living,
+					{Name: "Bedroom", ID: "bedroom_id", NewestEvents: types.Event{types.SensorTemperature: &types.SensorValue{Value: 18.0}}},
+				},
+			}, nil)
+			remoClient.EXPECT().GetAppliances().Return(&types.GetAppliancesResult{
+				StatusCode: 200,
+				Appliances: []*types.Appliance{
+					{ID: "meter_id", Type: "EL_SMART_METER", Device: living, SmartMeter: &types.SmartMeter{}},
+				},
+			}, nil)
+
+			c, _ := config.NewConfig(mockReader)
+			c.Labels = &config.StaticLabels{
+				Keys:       []string{"floor", "room"},
+				Devices:    map[string]map[string]string{"living_id": {"floor": "2", "room": "living"}},
+				Appliances: map[string]map[string]string{"meter_id": {"floor": "1", "room": "entrance"}},
+			}
+			e, err := NewAccountsExporter(c, []AccountGatherer{{Name: "labeled", Client: remoClient}})
+			Expect(err).Should(BeNil())
+
+			ch := make(chan prometheus.Metric)
+
+			go func() {
+				e.Collect(ch)
+				close(ch)
+			}()
+
+			rest := collectByName(ch)
+			temperatures := map[string]map[string]string{}
+			for _, m := range rest["remo_temperature"] {
+				r := readGauge(m)
+				temperatures[r.labels["name"]] = r.labels
+			}
+			Expect(temperatures["Living"]).To(HaveKeyWithValue("room", "living"))
+			Expect(temperatures["Living"]).To(HaveKeyWithValue("floor", "2"))
+			Expect(temperatures["Bedroom"]).To(HaveKeyWithValue("room", ""))
+			Expect(rest["remo_measured_instantaneous_energy_watt"]).To(HaveLen(1))
+			energy := readGauge(rest["remo_measured_instantaneous_energy_watt"][0])
+			Expect(energy.labels).To(HaveKeyWithValue("room", "entrance"))
+			Expect(energy.labels).To(HaveKeyWithValue("floor", "1"))
+		})
+
+		DescribeTable("should label the series according to the label mode",
+			func(labelMode string, expectedLabels []string, expectInfo bool) {
+				remoClient := mocks.NewMockRemoGatherer(mockCtrl)
+
+				living := &types.Device{
+					Name:            "Living",
+					ID:              "living_id",
+					FirmwareVersion: "Remo/1.0.62-gabbf5bd",
+					NewestEvents: types.Event{
+						types.SensorTemperature: &types.SensorValue{Value: 20.0},
+					},
+				}
+				remoClient.EXPECT().GetDevices().Return(&types.GetDevicesResult{
+					StatusCode: 200,
+					Devices:    []*types.Device{living},
+				}, nil)
+				remoClient.EXPECT().GetAppliances().Return(&types.GetAppliancesResult{
+					StatusCode: 200,
+					Appliances: []*types.Appliance{
+						{ID: "ac_id", Type: "AC", Nickname: "Aircon", Device: living},
+					},
+				}, nil)
+
+				c, _ := config.NewConfig(mockReader)
+				c.LabelMode = labelMode
+				e, err := NewAccountsExporter(c, []AccountGatherer{{Name: "mode_" + labelMode, Client: remoClient}})
+				Expect(err).Should(BeNil())
+
+				ch := make(chan prometheus.Metric)
+
+				go func() {
+					e.Collect(ch)
+					close(ch)
+				}()
+
+				rest := collectByName(ch)
+				Expect(rest["remo_temperature"]).To(HaveLen(1))
+				labels := []string{}
+				for name := range readGauge(rest["remo_temperature"][0]).labels {
+					labels = append(labels, name)
+				}
+				Expect(labels).To(ConsistOf(expectedLabels))
+
+				if !expectInfo {
+					Expect(rest["remo_device_info"]).To(BeEmpty())
+					Expect(rest["remo_appliance_info"]).To(BeEmpty())
+					return
+				}
+				Expect(rest["remo_device_info"]).To(HaveLen(1))
+				device := readGauge(rest["remo_device_info"][0])
+				Expect(device.value).To(Equal(1.0))
+				Expect(device.labels).To(Equal(map[string]string{"account": "mode_" + labelMode, "id": "living_id", "name": "Living", "firmware_version": "Remo/1.0.62-gabbf5bd"}))
+				Expect(rest["remo_appliance_info"]).To(HaveLen(1))
+				appliance := readGauge(rest["remo_appliance_info"][0])
+				Expect(appliance.labels).To(Equal(map[string]string{"account": "mode_" + labelMode, "id": "ac_id", "nickname": "Aircon", "type": "AC", "device_id": "living_id"}))
+			},
+			Entry("name", config.LabelModeName, []string{"account", "name", "id"}, false),
+			Entry("id", config.LabelModeID, []string{"account", "id"}, true),
+			Entry("both", config.LabelModeBoth, []string{"account", "name", "id"}, true),
+		)
+
+		It("should only collect the requested collector groups", func() {
+			remoClient := mocks.NewMockRemoGatherer(mockCtrl)
+
+			living := &types.Device{
+				Name: "Living",
+				ID:   "living_id",
+				NewestEvents: types.Event{
+					types.SensorTemperature: &types.SensorValue{Value: 20.0},
+				},
+			}
+			remoClient.EXPECT().GetDevices().Return(&types.GetDevicesResult{
+				StatusCode: 200,
+				Devices:    []*types.Device{living},
+				Meta:       &types.Meta{RateLimitLimit: 30},
+			}, nil)
+			remoClient.EXPECT().GetAppliances().Return(&types.GetAppliancesResult{
+				StatusCode: 200,
+				Appliances: []*types.Appliance{
+					{ID: "meter_id", Type: "EL_SMART_METER", Device: living, SmartMeter: &types.SmartMeter{}},
+				},
+			}, nil)
+
+			c, _ := config.NewConfig(mockReader)
+			e, err := NewAccountsExporter(c, []AccountGatherer{{Name: "grouped", Client: remoClient}})
+			Expect(err).Should(BeNil())
+
+			filtered, err := e.Filter([]string{config.CollectorEnergy})
+			Expect(err).Should(BeNil())
+
+			ch := make(chan prometheus.Metric)
+
+			go func() {
+				filtered.Collect(ch)
+				close(ch)
+			}()
+
+			rest := collectByName(ch)
+			Expect(rest["remo_measured_instantaneous_energy_watt"]).To(HaveLen(1))
+			Expect(rest["remo_temperature"]).To(BeEmpty())
+			Expect(rest["remo_x_rate_limit_limit"]).To(BeEmpty())
+			Expect(rest["remo_auth_valid"]).To(BeEmpty())
+			Expect(rest["remo_http_requests_total"]).To(BeEmpty())
+
+			_, err = e.Filter([]string{"battery"})
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("should not request the appliances when their collectors are disabled", func() {
+			remoClient := mocks.NewMockRemoGatherer(mockCtrl)
+			remoClient.EXPECT().GetDevices().Return(&types.GetDevicesResult{
+				StatusCode: 200,
+				Devices: []*types.Device{
+					{Name: "Living", ID: "living_id", NewestEvents: types.Event{types.SensorTemperature: &types.SensorValue{Value: 20.0}}},
+				},
+				Meta: &types.Meta{RateLimitLimit: 30},
+			}, nil)
+
+			c, _ := config.NewConfig(mockReader)
+			c.Collectors = []string{config.CollectorSensors, config.CollectorRateLimit}
+			e, err := NewAccountsExporter(c, []AccountGatherer{{Name: "sensors_only", Client: remoClient}})
+			Expect(err).Should(BeNil())
+			Expect(e.CollectorEnabled(config.CollectorSelf)).To(BeFalse())
+
+			_, err = e.Filter([]string{config.CollectorEnergy})
+			Expect(err).NotTo(BeNil())
+
+			ch := make(chan prometheus.Metric)
+
+			go func() {
+				e.Collect(ch)
+				close(ch)
+			}()
+
+			rest := collectByName(ch)
+			Expect(rest["remo_temperature"]).To(HaveLen(1))
+			Expect(rest["remo_x_rate_limit_limit"]).To(HaveLen(1))
+			Expect(rest["remo_cache_misses_total"]).To(BeEmpty())
+		})
+
+		It("should export the v2 names alongside the v1 names under the namespace", func() {
+			remoClient := mocks.NewMockRemoGatherer(mockCtrl)
+
+			living := &types.Device{
+				Name: "Living",
+				ID:   "living_id",
+				NewestEvents: types.Event{
+					types.SensorTemperature: &types.SensorValue{Value: 20.0},
+					types.SensorHumidity:    &types.SensorValue{Value: 45.0},
+				},
+			}
+			remoClient.EXPECT().GetDevices().Return(&types.GetDevicesResult{
+				StatusCode: 200,
+				Devices:    []*types.Device{living},
+			}, nil)
+			remoClient.EXPECT().GetAppliances().Return(&types.GetAppliancesResult{
+				StatusCode: 200,
+				Appliances: []*types.Appliance{
+					{
+						ID:     "meter_id",
+						Type:   "EL_SMART_METER",
+						Device: living,
+						SmartMeter: &types.SmartMeter{
+							EchonetliteProperties: []*types.EchonetliteProperty{
+								{Epc: EpcCoefficient, Val: "2"},
+								{Epc: EpcNormalDirectionCumulativeElectricEnergy, Val: "1500"},
+								{Epc: EpcCumulativeElectricEnergyUnit, Val: "1"},
+							},
+						},
+					},
+				},
+			}, nil)
+
+			c, _ := config.NewConfig(mockReader)
+			c.Namespace = "home"
+			c.MetricNames = config.MetricNamesBoth
+			e, err := NewAccountsExporter(c, []AccountGatherer{{Name: "renamed", Client: remoClient}})
+			Expect(err).Should(BeNil())
+
+			ch := make(chan prometheus.Metric)
+
+			go func() {
+				e.Collect(ch)
+				close(ch)
+			}()
+
+			rest := collectByName(ch)
+			Expect(rest["remo_temperature"]).To(BeEmpty())
+			Expect(rest["home_temperature"]).To(HaveLen(1))
+			Expect(rest["home_temperature_celsius"]).To(HaveLen(1))
+			Expect(readGauge(rest["home_temperature_celsius"][0]).value).To(Equal(20.0))
+			Expect(readGauge(rest["home_humidity"][0]).value).To(Equal(45.0))
+			Expect(readGauge(rest["home_humidity_ratio"][0]).value).To(Equal(0.45))
+			Expect(readCounter(rest["home_normal_direction_cumulative_electric_energy"][0]).value).To(Equal(1500.0))
+			Expect(readCounter(rest["home_normal_direction_cumulative_electric_energy_kwh_total"][0]).value).To(BeNumerically("~", 300.0, 1e-9))
+			Expect(rest["home_coefficient"]).To(HaveLen(1))
+			Expect(rest["home_http_requests_total"]).To(HaveLen(2))
+		})
+
+		It("should convert the energy to kWh if the smart meter doesn't report the unit", func() {
+			remoClient := mocks.NewMockRemoGatherer(mockCtrl)
+
+			living := &types.Device{Name: "Living", ID: "living_id"}
+			remoClient.EXPECT().GetDevices().Return(&types.GetDevicesResult{
+				StatusCode: 200,
+				Devices:    []*types.Device{living},
+			}, nil)
+			remoClient.EXPECT().GetAppliances().Return(&types.GetAppliancesResult{
+				StatusCode: 200,
+				Appliances: []*types.Appliance{
+					{
+						ID:     "meter_id",
+						Type:   "EL_SMART_METER",
+						Device: living,
+						SmartMeter: &types.SmartMeter{
+							EchonetliteProperties: []*types.EchonetliteProperty{
+								{Epc: EpcNormalDirectionCumulativeElectricEnergy, Val: "1500"},
+								{Epc: EpcReverseDirectionCumulativeElectricEnergy, Val: "20"},
+							},
+						},
+					},
+				},
+			}, nil)
+
+			c, _ := config.NewConfig(mockReader)
+			c.MetricNames = config.MetricNamesV2
+			e, err := NewExporter(c, remoClient)
+			Expect(err).Should(BeNil())
+
+			ch := make(chan prometheus.Metric)
+
+			go func() {
+				e.Collect(ch)
+				close(ch)
+			}()
+
+			rest := collectByName(ch)
+			Expect(readCounter(rest["remo_normal_direction_cumulative_electric_energy_kwh_total"][0]).value).To(Equal(1500.0))
+			Expect(readCounter(rest["remo_reverse_direction_cumulative_electric_energy_kwh_total"][0]).value).To(Equal(20.0))
+		})
+
+		It("should report an invalid oauth token", func() {
+			authClient := mocks.NewMockAuthHttpDoer(mockCtrl)
+			authClient.EXPECT().Get(gomock.Any()).Return(&http.Response{
+				StatusCode: 401,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"code": 401001, "message": "Unauthorized"}`)),
+				Header:     make(http.Header, 0),
+			}, nil).Times(1)
+
+			c, _ := config.NewConfig(mockReader)
+			rc, _ := NewRemoClient(c, authClient)
+			e, err := NewAccountsExporter(c, []AccountGatherer{{Name: "revoked", Client: rc}})
+			Expect(err).Should(BeNil())
+			Expect(e.Ready()).Should(BeNil())
+
+			for i := 0; i < 2; i++ {
+				ch := make(chan prometheus.Metric)
+
+				go func() {
+					e.Collect(ch)
+					close(ch)
+				}()
+
+				rest := collectByName(ch)
+				Expect(rest["remo_auth_valid"]).To(HaveLen(1))
+				Expect(readGauge(rest["remo_auth_valid"][0]).value).To(BeNumerically("==", 0))
+				Expect(rest["remo_api_errors_total"]).To(HaveLen(1))
+				Expect(readCounter(rest["remo_api_errors_total"][0]).value).To(BeNumerically("==", 1))
+			}
+			Expect(e.Ready()).ShouldNot(BeNil())
 		})
 	})
 })
@@ -0,0 +1,107 @@
+package exporter
+
+import (
+	"github.com/kenfdev/remo-exporter/config"
+	"github.com/kenfdev/remo-exporter/types"
+)
+
+// Kinds of the entities counted by remo_filtered_entities
+const (
+	entityDevice    = "device"
+	entityAppliance = "appliance"
+)
+
+func matchesDevice(r *config.FilterRule, d *types.Device) bool {
+	if d == nil {
+		return false
+	}
+	if r.DeviceID != "" {
+		return r.DeviceID == d.ID
+	}
+	if r.DeviceName != nil {
+		return r.DeviceName.MatchString(d.Name)
+	}
+	return false
+}
+
+func matchesAppliance(r *config.FilterRule, app *types.Appliance) bool {
+	if r.ApplianceType != "" {
+		return r.ApplianceType == app.Type
+	}
+	if r.ApplianceNickname != nil {
+		return r.ApplianceNickname.MatchString(app.Nickname)
+	}
+	return false
+}
+
+// deviceAllowed reports whether the metrics of the device are exported
+func deviceAllowed(f *config.Filters, d *types.Device) bool {
+	if f == nil {
+		return true
+	}
+	for _, r := range f.Exclude {
+		if matchesDevice(r, d) {
+			return false
+		}
+	}
+	included, hasRules := false, false
+	for _, r := range f.Include {
+		if r.IsDeviceRule() {
+			hasRules = true
+			included = included || matchesDevice(r, d)
+		}
+	}
+	return !hasRules || included
+}
+
+// applianceAllowed reports whether the metrics of the appliance are exported.
+// The device of the appliance must be allowed as well.
+func applianceAllowed(f *config.Filters, app *types.Appliance) bool {
+	if f == nil {
+		return true
+	}
+	if app.Device != nil && !deviceAllowed(f, app.Device) {
+		return false
+	}
+	for _, r := range f.Exclude {
+		if matchesAppliance(r, app) {
+			return false
+		}
+	}
+	included, hasRules := false, false
+	for _, r := range f.Include {
+		if !r.IsDeviceRule() {
+			hasRules = true
+			included = included || matchesAppliance(r, app)
+		}
+	}
+	return !hasRules || included
+}
+
+// filterDevices returns the allowed devices and the number of filtered ones
+func filterDevices(f *config.Filters, devices []*types.Device) ([]*types.Device, int) {
+	if f == nil {
+		return devices, 0
+	}
+	res := []*types.Device{}
+	for _, d := range devices {
+		if deviceAllowed(f, d) {
+			res = append(res, d)
+		}
+	}
+	return res, len(devices) - len(res)
+}
+
+// filterAppliances returns the allowed appliances and the number of filtered ones
+func filterAppliances(f *config.Filters, apps []*types.Appliance) ([]*types.Appliance, int) {
+	if f == nil {
+		return apps, 0
+	}
+	res := []*types.Appliance{}
+	for _, app := range apps {
+		if applianceAllowed(f, app) {
+			res = append(res, app)
+		}
+	}
+	return res, len(apps) - len(res)
+}
@@ -0,0 +1,106 @@
+package exporter
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/kenfdev/remo-exporter/config"
+)
+
+// handler serves the metrics of an Exporter
+type handler struct {
+	exporter  *Exporter
+	gatherers []prometheus.Gatherer
+}
+
+// NewHandler returns an http.Handler serving the metrics of the exporter, so it can be mounted in any server.
+// collect[] parameters, e.g. ?collect[]=energy, restrict a scrape to some collector groups like node_exporter.
+// The metrics of gatherers, e.g. the Go runtime metrics of the program, are served as part of the self group.
+// The requests to the remo API are cancelled when the scrape is, e.g. because Prometheus timed out.
+// The units of the metrics are written if the scraper negotiates the OpenMetrics format.
+func NewHandler(e *Exporter, gatherers ...prometheus.Gatherer) http.Handler {
+	return &handler{
+		exporter:  e,
+		gatherers: gatherers,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	names := r.URL.Query()["collect[]"]
+	withSelf := h.exporter.CollectorEnabled(config.CollectorSelf)
+	collector := &filteredCollector{exporter: h.exporter, groups: allCollectors, ctx: r.Context()}
+	if len(names) > 0 {
+		filtered, err := h.exporter.filter(r.Context(), names)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid collect[] parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		collector = filtered
+		withSelf = newCollectorSet(names)[config.CollectorSelf]
+	}
+	// the collector is registered for every scrape to collect with the context of the request
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collector); err != nil {
+		http.Error(w, fmt.Sprintf("failed to register the collectors: %v", err), http.StatusInternalServerError)
+		return
+	}
+	gatherers := prometheus.Gatherers{registry}
+	if withSelf {
+		gatherers = append(gatherers, h.gatherers...)
+	}
+	h.serveMetrics(w, r, gatherers)
+}
+
+// serveMetrics writes the gathered metrics in the format negotiated with the scraper.
+// promhttp can't write the OpenMetrics UNIT metadata, so the metrics are encoded here.
+func (h *handler) serveMetrics(w http.ResponseWriter, r *http.Request, g prometheus.Gatherer) {
+	mfs, err := g.Gather()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("An error has occurred while serving metrics:\n\n%v", err), http.StatusInternalServerError)
+		return
+	}
+
+	format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+	w.Header().Set("Content-Type", string(format))
+	var out io.Writer = w
+	if gzipAccepted(r.Header) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+
+	enc := expfmt.NewEncoder(out, format, expfmt.WithUnit())
+	for _, mf := range mfs {
+		if unit, ok := h.exporter.descs.units[mf.GetName()]; ok {
+			mf.Unit = proto.String(unit)
+		}
+		if err := enc.Encode(mf); err != nil {
+			h.exporter.logger.Errorf("Failed to encode metric family %s: %v", mf.GetName(), err)
+			return
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			h.exporter.logger.Errorf("Failed to finish the metrics: %v", err)
+		}
+	}
+}
+
+// gzipAccepted returns whether the Accept-Encoding header of a request includes gzip
+func gzipAccepted(header http.Header) bool {
+	for _, part := range strings.Split(header.Get("Accept-Encoding"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "gzip" || strings.HasPrefix(part, "gzip;") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package exporter_test
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kenfdev/remo-exporter/config"
+	. "github.com/kenfdev/remo-exporter/exporter"
+	"github.com/kenfdev/remo-exporter/mocks"
+	"github.com/kenfdev/remo-exporter/remo"
+	"github.com/kenfdev/remo-exporter/remo/remotest"
+	"github.com/kenfdev/remo-exporter/types"
+)
+
+var _ = Describe("Handler", func() {
+	var (
+		mockCtrl   *gomock.Controller
+		mockReader *mocks.MockReader
+		c          *config.Config
+	)
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockReader = mocks.NewMockReader(mockCtrl)
+		c, _ = config.NewConfig(mockReader)
+	})
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	newExporter := func(opts ...ExporterOption) (*Exporter, error) {
+		remoClient := mocks.NewMockRemoGatherer(mockCtrl)
+		remoClient.EXPECT().GetDevices().Return(&types.GetDevicesResult{
+			StatusCode: 200,
+			Devices: []*types.Device{
+				{Name: "Living", ID: "living_id", NewestEvents: types.Event{types.SensorTemperature: &types.SensorValue{Value: 20.0}}},
+			},
+		}, nil).AnyTimes()
+		remoClient.EXPECT().GetAppliances().Return(&types.GetAppliancesResult{StatusCode: 200}, nil).AnyTimes()
+		return NewExporter(c, remoClient, opts...)
+	}
+
+	scrape := func(h http.Handler, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics"+query, nil))
+		return w
+	}
+
+	It("should serve the collector groups and the gatherers as part of the self group", func() {
+		program := prometheus.NewRegistry()
+		up := prometheus.NewGauge(prometheus.GaugeOpts{Name: "program_up", Help: "Whether the program is up"})
+		up.Set(1)
+		program.MustRegister(up)
+
+		e, err := newExporter()
+		Expect(err).Should(BeNil())
+		h := NewHandler(e, program)
+
+		w := scrape(h, "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring(`remo_temperature{account="default",id="living_id",name="Living"} 20`))
+		Expect(w.Body.String()).To(ContainSubstring("program_up 1"))
+
+		w = scrape(h, "?collect[]=sensors")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring("remo_temperature"))
+		Expect(w.Body.String()).NotTo(ContainSubstring("program_up"))
+		Expect(w.Body.String()).NotTo(ContainSubstring("remo_http_requests_total"))
+
+		w = scrape(h, "?collect[]=self")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).NotTo(ContainSubstring("remo_temperature"))
+		Expect(w.Body.String()).To(ContainSubstring("program_up 1"))
+
+		Expect(scrape(h, "?collect[]=battery").Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should write the units of the metrics if OpenMetrics is negotiated", func() {
+		c.MetricNames = config.MetricNamesV2
+		e, err := newExporter()
+		Expect(err).Should(BeNil())
+		h := NewHandler(e)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		r.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+		h.ServeHTTP(w, r)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).To(HavePrefix("application/openmetrics-text"))
+		Expect(w.Body.String()).To(ContainSubstring("# UNIT remo_temperature_celsius celsius\n"))
+		Expect(w.Body.String()).To(ContainSubstring("# UNIT remo_http_response_size_bytes bytes\n"))
+		Expect(w.Body.String()).To(HaveSuffix("# EOF\n"))
+
+		w = scrape(h, "")
+		Expect(w.Header().Get("Content-Type")).To(HavePrefix("text/plain"))
+		Expect(w.Body.String()).To(ContainSubstring(`remo_temperature_celsius{account="default",id="living_id",name="Living"} 20`))
+	})
+
+	It("should compress the metrics if the scraper accepts gzip", func() {
+		e, err := newExporter()
+		Expect(err).Should(BeNil())
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		NewHandler(e).ServeHTTP(w, r)
+
+		Expect(w.Header().Get("Content-Encoding")).To(Equal("gzip"))
+		gz, err := gzip.NewReader(w.Body)
+		Expect(err).Should(BeNil())
+		body, err := io.ReadAll(gz)
+		Expect(err).Should(BeNil())
+		Expect(string(body)).To(ContainSubstring("remo_temperature"))
+	})
+
+	It("should cancel the requests to the remo API with the scrape", func() {
+		server := remotest.NewServer("dummy_token")
+		defer server.Close()
+		server.SetDevices([]*types.Device{
+			{Name: "Living", ID: "living_id", NewestEvents: types.Event{types.SensorTemperature: &types.SensorValue{Value: 20.0}}},
+		})
+		e, err := NewExporter(c, NewClient(remo.NewClient("dummy_token", remo.WithBaseURL(server.URL))))
+		Expect(err).Should(BeNil())
+		h := NewHandler(e)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil).WithContext(ctx))
+		Expect(w.Body.String()).NotTo(ContainSubstring("remo_temperature"))
+		Expect(server.Requests()).To(Equal(0))
+
+		w = scrape(h, "")
+		Expect(w.Body.String()).To(ContainSubstring(`remo_temperature{account="default",id="living_id",name="Living"} 20`))
+	})
+
+	It("should not share the metrics of two exporters", func() {
+		first, err := newExporter()
+		Expect(err).Should(BeNil())
+		second, err := newExporter()
+		Expect(err).Should(BeNil())
+
+		scrape(NewHandler(first), "")
+		scrape(NewHandler(first), "")
+		w := scrape(NewHandler(second), "")
+
+		Expect(w.Body.String()).To(ContainSubstring(`remo_http_requests_total{account="default",api="devices",code="200"} 1`))
+	})
+
+	It("should register the exporter with the registerer", func() {
+		registry := prometheus.NewRegistry()
+
+		_, err := newExporter(WithRegisterer(registry))
+		Expect(err).Should(BeNil())
+		families, err := registry.Gather()
+		Expect(err).Should(BeNil())
+		Expect(families).NotTo(BeEmpty())
+
+		_, err = newExporter(WithRegisterer(registry))
+		Expect(err).NotTo(BeNil())
+	})
+})
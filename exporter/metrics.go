@@ -0,0 +1,281 @@
+package exporter
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kenfdev/remo-exporter/config"
+	"github.com/kenfdev/remo-exporter/types"
+)
+
+// namedMetric is a metric exported under its v1 name, its v2 name or both depending on config.MetricNames.
+// A desc is nil if the metric isn't exported under that name.
+type namedMetric struct {
+	v1     *prometheus.Desc
+	v1Type prometheus.ValueType
+	v2     *prometheus.Desc
+	v2Type prometheus.ValueType
+}
+
+func (m *namedMetric) describe(ch chan<- *prometheus.Desc) {
+	if m.v1 != nil {
+		ch <- m.v1
+	}
+	if m.v2 != nil {
+		ch <- m.v2
+	}
+}
+
+// emit sends the v1 value under the v1 name and the v2 value, which may be converted to a base unit, under the v2 name
+func (m *namedMetric) emit(ch chan<- prometheus.Metric, v1Value float64, v2Value float64, labelValues ...string) {
+	if m.v1 != nil {
+		ch <- prometheus.MustNewConstMetric(m.v1, m.v1Type, v1Value, labelValues...)
+	}
+	if m.v2 != nil {
+		ch <- prometheus.MustNewConstMetric(m.v2, m.v2Type, v2Value, labelValues...)
+	}
+}
+
+// metricName is the name, help and type of a metric in one naming scheme
+type metricName struct {
+	name      string
+	help      string
+	valueType prometheus.ValueType
+}
+
+// metricDescs are the descriptions of the metrics of an exporter.
+// The labels of the device and smart meter metrics depend on the label mode and end with the keys of the static labels of the config.
+type metricDescs struct {
+	withName bool
+	withInfo bool
+	// units are the OpenMetrics units of the metrics keyed by their names including the namespace
+	units map[string]string
+
+	temperature                 *namedMetric
+	humidity                    *namedMetric
+	illumination                *namedMetric
+	motion                      *namedMetric
+	sensorValue                 *namedMetric
+	sensorTimestamp             *namedMetric
+	normalElectricEnergy        *namedMetric
+	reverseElectricEnergy       *namedMetric
+	coefficient                 *namedMetric
+	electricEnergyUnit          *namedMetric
+	electricEnergyDigits        *namedMetric
+	measuredInstantaneousEnergy *namedMetric
+	deviceInfo                  *prometheus.Desc
+	applianceInfo               *prometheus.Desc
+
+	rateLimitLimit        *namedMetric
+	rateLimitReset        *namedMetric
+	rateLimitRemaining    *namedMetric
+	apiRateLimitRemaining *prometheus.Desc
+	cacheAge              *prometheus.Desc
+	authValid             *prometheus.Desc
+	filteredEntities      *prometheus.Desc
+
+	self *selfMetrics
+}
+
+// namespaceOf returns the prefix of the metric names of the config
+func namespaceOf(c *config.Config) string {
+	if c.Namespace == "" {
+		return config.DefaultNamespace
+	}
+	return c.Namespace
+}
+
+func newMetricDescs(c *config.Config) *metricDescs {
+	namespace := namespaceOf(c)
+	d := &metricDescs{
+		withName: c.LabelMode != config.LabelModeID,
+		withInfo: c.LabelMode == config.LabelModeID || c.LabelMode == config.LabelModeBoth,
+		self:     newSelfMetrics(namespace),
+	}
+	withV1 := c.MetricNames != config.MetricNamesV2
+	withV2 := c.MetricNames == config.MetricNamesV2 || c.MetricNames == config.MetricNamesBoth
+
+	desc := func(name string, help string, labels []string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labels, nil)
+	}
+	named := func(v1 metricName, v2 metricName, labels []string) *namedMetric {
+		m := &namedMetric{v1Type: v1.valueType, v2Type: v2.valueType}
+		if withV1 {
+			m.v1 = desc(v1.name, v1.help, labels)
+		}
+		// a metric whose name didn't change is exported once
+		if withV2 && (v2.name != v1.name || !withV1) {
+			m.v2 = desc(v2.name, v2.help, labels)
+		}
+		return m
+	}
+	same := func(name string, help string, valueType prometheus.ValueType, labels []string) *namedMetric {
+		return named(metricName{name, help, valueType}, metricName{name, help, valueType}, labels)
+	}
+
+	identity := []string{"account", "name", "id"}
+	if !d.withName {
+		identity = []string{"account", "id"}
+	}
+	labelKeys := c.Labels.LabelKeys()
+	entityLabels := append(append([]string{}, identity...), labelKeys...)
+	sensorLabels := append(append(append([]string{}, identity...), "sensor"), labelKeys...)
+	gauge, counter := prometheus.GaugeValue, prometheus.CounterValue
+
+	d.temperature = named(
+		metricName{"temperature", "The temperature of the remo device", gauge},
+		metricName{"temperature_celsius", "The temperature of the remo device in degrees Celsius", gauge},
+		entityLabels)
+	d.humidity = named(
+		metricName{"humidity", "The humidity of the remo device", gauge},
+		metricName{"humidity_ratio", "The relative humidity of the remo device as a ratio between 0 and 1", gauge},
+		entityLabels)
+	d.illumination = named(
+		metricName{"illumination", "The illumination of the remo device", gauge},
+		metricName{"illuminance", "The illuminance measured by the remo device", gauge},
+		entityLabels)
+	d.motion = named(
+		metricName{"motion", "The motion of the remo device", gauge},
+		metricName{"motion_last_timestamp_seconds", "The time the remo device last detected a motion", gauge},
+		entityLabels)
+	d.sensorValue = same("sensor_value", "The value of a sensor of the remo device which has no dedicated metric", gauge, sensorLabels)
+	d.sensorTimestamp = same("sensor_timestamp_seconds", "The time the value of a sensor of the remo device which has no dedicated metric was created", gauge, sensorLabels)
+	d.normalElectricEnergy = named(
+		metricName{"normal_direction_cumulative_electric_energy", "The raw value for cumulative electric energy in normal direction", counter},
+		metricName{"normal_direction_cumulative_electric_energy_kwh_total", "The cumulative electric energy in normal direction in kWh", counter},
+		entityLabels)
+	d.reverseElectricEnergy = named(
+		metricName{"reverse_direction_cumulative_electric_energy", "The raw value for cumulative electric energy in reverse direction", counter},
+		metricName{"reverse_direction_cumulative_electric_energy_kwh_total", "The cumulative electric energy in reverse direction in kWh", counter},
+		entityLabels)
+	d.coefficient = same("coefficient", "The coefficient for cumulative electric energy", gauge, entityLabels)
+	d.electricEnergyUnit = same("cumulative_electric_energy_unit_kilowatt_hour", "The unit in kWh for cumulative electric energy", gauge, entityLabels)
+	d.electricEnergyDigits = same("cumulative_electric_energy_effective_digits", "The number of effective digits for cumulative electric energy", gauge, entityLabels)
+	d.measuredInstantaneousEnergy = named(
+		metricName{"measured_instantaneous_energy_watt", "The measured instantaneous energy in W", gauge},
+		metricName{"measured_instantaneous_power_watts", "The measured instantaneous electric power in watts", gauge},
+		entityLabels)
+	d.deviceInfo = desc("device_info", "The names of the remo device. Always 1", []string{"account", "id", "name", "firmware_version"})
+	d.applianceInfo = desc("appliance_info", "The names of the appliance. Always 1", []string{"account", "id", "nickname", "type", "device_id"})
+
+	d.rateLimitLimit = same("x_rate_limit_limit", "The rate limit for the remo API", gauge, []string{"account"})
+	d.rateLimitReset = named(
+		metricName{"x_rate_limit_reset", "The time in which the rate limit for the remo API will be reset", gauge},
+		metricName{"x_rate_limit_reset_timestamp_seconds", "The time the rate limit for the remo API will be reset", gauge},
+		[]string{"account"})
+	d.rateLimitRemaining = same("x_rate_limit_remaining", "The remaining number of request for the remo API", gauge, []string{"account"})
+	d.apiRateLimitRemaining = desc("api_rate_limit_remaining", "The remaining number of request for the remo API labeled by api", []string{"account", "api"})
+	d.cacheAge = desc("cache_age_seconds", "The number of seconds since the cached response was fetched labeled by api", []string{"account", "api"})
+	d.authValid = desc("auth_valid", "Whether the oauth token was accepted by the remo API on the last request", []string{"account"})
+	d.filteredEntities = desc("filtered_entities", "The number of devices or appliances not exported because of the filters labeled by kind", []string{"account", "kind"})
+
+	d.units = map[string]string{}
+	for name, unit := range metricUnits {
+		d.units[prometheus.BuildFQName(namespace, "", name)] = unit
+	}
+	return d
+}
+
+// metricUnits are the units of the metrics which are written as OpenMetrics UNIT metadata.
+// Every name ends with its unit, followed by _total for counters.
+var metricUnits = map[string]string{
+	"temperature_celsius":                                    "celsius",
+	"humidity_ratio":                                         "ratio",
+	"motion_last_timestamp_seconds":                          "seconds",
+	"sensor_timestamp_seconds":                               "seconds",
+	"normal_direction_cumulative_electric_energy_kwh_total":  "kwh",
+	"reverse_direction_cumulative_electric_energy_kwh_total": "kwh",
+	"measured_instantaneous_power_watts":                     "watts",
+	"x_rate_limit_reset_timestamp_seconds":                   "seconds",
+	"cache_age_seconds":                                      "seconds",
+	"http_request_duration_seconds":                          "seconds",
+	"http_response_size_bytes":                               "bytes",
+}
+
+// labelValues returns the values of the labels of an entity metric.
+// static are the values of the static labels of the entity.
+func (d *metricDescs) labelValues(static []string, account string, name string, id string, extra ...string) []string {
+	values := []string{account}
+	if d.withName {
+		values = append(values, name)
+	}
+	values = append(values, id)
+	values = append(values, extra...)
+	return append(values, static...)
+}
+
+// selfMetrics are the metrics of the exporter's own requests to the remo API
+type selfMetrics struct {
+	httpRequestsTotal   *prometheus.CounterVec
+	cacheHitsTotal      *prometheus.CounterVec
+	cacheMissesTotal    *prometheus.CounterVec
+	httpRequestDuration *prometheus.HistogramVec
+	httpResponseSize    *prometheus.HistogramVec
+	apiErrorsTotal      *prometheus.CounterVec
+	apiUnknownFields    *prometheus.GaugeVec
+}
+
+func newSelfMetrics(namespace string) *selfMetrics {
+	return &selfMetrics{
+		httpRequestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "The total number of requests labeled by response code",
+		},
+			[]string{"account", "code", "api"},
+		),
+		cacheHitsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_hits_total",
+			Help:      "The total number of results served from the cache labeled by api",
+		},
+			[]string{"account", "api"},
+		),
+		cacheMissesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_misses_total",
+			Help:      "The total number of results fetched from the remo API labeled by api",
+		},
+			[]string{"account", "api"},
+		),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "The latency of requests to the remo API labeled by api",
+			Buckets:   prometheus.DefBuckets,
+		},
+			[]string{"account", "api"},
+		),
+		httpResponseSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_response_size_bytes",
+			Help:      "The size of successful responses from the remo API labeled by api",
+			Buckets:   prometheus.ExponentialBuckets(256, 4, 8),
+		},
+			[]string{"account", "api"},
+		),
+		apiErrorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "api_errors_total",
+			Help:      "The total number of error responses from the remo API labeled by api, status and error code",
+		},
+			[]string{"account", "api", "status", "code"},
+		),
+		apiUnknownFields: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "api_unknown_fields",
+			Help:      "Fields in the remo API responses which are unknown to the exporter labeled by object and field",
+		},
+			[]string{"account", "object", "field"},
+		),
+	}
+}
+
+func (m *selfMetrics) countAPIError(account string, api string, apiErr *types.APIError) {
+	code := ""
+	if apiErr.Code != 0 {
+		code = strconv.Itoa(apiErr.Code)
+	}
+	m.apiErrorsTotal.WithLabelValues(account, api, strconv.Itoa(apiErr.StatusCode), code).Inc()
+}
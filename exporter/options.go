@@ -0,0 +1,133 @@
+package exporter
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kenfdev/remo-exporter/config"
+	"github.com/kenfdev/remo-exporter/log"
+)
+
+// ExporterOption configures an Exporter
+type ExporterOption func(*Exporter)
+
+// WithConfig sets the filters, static labels, collector groups, label mode and metric names of the exporter.
+// The defaults of an empty config.Config are used without it.
+func WithConfig(c *config.Config) ExporterOption {
+	return func(e *Exporter) {
+		e.config = c
+	}
+}
+
+// WithClient adds the RemoGatherer of the default account
+func WithClient(client RemoGatherer) ExporterOption {
+	return WithAccounts(AccountGatherer{Name: config.DefaultAccountName, Client: client})
+}
+
+// WithAccounts adds accounts to the exporter. Every metric is labeled by the name of the account.
+func WithAccounts(accounts ...AccountGatherer) ExporterOption {
+	return func(e *Exporter) {
+		e.accounts = append(e.accounts, accounts...)
+	}
+}
+
+// WithRegisterer registers the exporter with r when it's created
+func WithRegisterer(r prometheus.Registerer) ExporterOption {
+	return func(e *Exporter) {
+		e.registerer = r
+	}
+}
+
+// WithClock sets the function returning the current time, e.g. to compute the age of cached results in tests
+func WithClock(now func() time.Time) ExporterOption {
+	return func(e *Exporter) {
+		e.now = now
+	}
+}
+
+// WithLogger sets the logger of the exporter. It defaults to log.Default().
+func WithLogger(logger log.Logger) ExporterOption {
+	return func(e *Exporter) {
+		e.logger = logger
+	}
+}
+
+// withSelfMetrics makes the exporter count its requests to the remo API in m,
+// e.g. to keep the counters of a probe target between its probes
+func withSelfMetrics(m *selfMetrics) ExporterOption {
+	return func(e *Exporter) {
+		e.self = m
+	}
+}
+
+// RemoClientOption configures a RemoClient
+type RemoClientOption func(*RemoClient)
+
+// CachePolicy is how long a RemoClient serves the results of the Remo API from its cache
+type CachePolicy struct {
+	Devices    time.Duration
+	Appliances time.Duration
+	// AppliancesMode is config.AppliancesModeEnabled, config.AppliancesModeDisabled or config.AppliancesModeAuto
+	AppliancesMode string
+}
+
+// DefaultCachePolicy caches the results for a minute like the default config
+var DefaultCachePolicy = CachePolicy{
+	Devices:        time.Minute,
+	Appliances:     time.Minute,
+	AppliancesMode: config.AppliancesModeEnabled,
+}
+
+// cachePolicyOf returns the cache policy of the config
+func cachePolicyOf(c *config.Config) CachePolicy {
+	return CachePolicy{
+		Devices:        time.Duration(c.DevicesCacheInvalidationSeconds) * time.Second,
+		Appliances:     time.Duration(c.AppliancesCacheInvalidationSeconds) * time.Second,
+		AppliancesMode: c.AppliancesMode,
+	}
+}
+
+// WithCachePolicy sets how long the results are cached. It defaults to DefaultCachePolicy.
+func WithCachePolicy(p CachePolicy) RemoClientOption {
+	return func(c *RemoClient) {
+		c.setCachePolicy(p)
+	}
+}
+
+// WithAuthRetry sets how long requests are paused after the oauth token was rejected. It defaults to 5 minutes.
+func WithAuthRetry(d time.Duration) RemoClientOption {
+	return func(c *RemoClient) {
+		c.authRetrySeconds = int(d / time.Second)
+	}
+}
+
+// WithSchemaDriftDetection makes the client report the fields of the responses unknown to the types package
+func WithSchemaDriftDetection(enabled bool) RemoClientOption {
+	return func(c *RemoClient) {
+		c.setSchemaDriftDetection(enabled)
+	}
+}
+
+// WithClientClock sets the function returning the current time, e.g. to expire the cache in tests without sleeping
+func WithClientClock(now func() time.Time) RemoClientOption {
+	return func(c *RemoClient) {
+		c.now = now
+	}
+}
+
+// WithClientLogger sets the logger of the client. It defaults to log.Default().
+func WithClientLogger(logger log.Logger) RemoClientOption {
+	return func(c *RemoClient) {
+		c.logger = logger
+	}
+}
+
+// remoClientOptionsOf returns the options of a RemoClient set by the config
+func remoClientOptionsOf(c *config.Config) []RemoClientOption {
+	return []RemoClientOption{
+		WithCachePolicy(cachePolicyOf(c)),
+		WithAuthRetry(time.Duration(c.AuthRetrySeconds) * time.Second),
+		WithSchemaDriftDetection(c.SchemaDriftDetection),
+	}
+}
@@ -0,0 +1,120 @@
+package exporter
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/kenfdev/remo-exporter/config"
+	authHttp "github.com/kenfdev/remo-exporter/http"
+	"github.com/kenfdev/remo-exporter/log"
+)
+
+// TokenLookup looks up the oauth token of a probe target
+type TokenLookup interface {
+	Lookup(target string) (string, error)
+}
+
+// AuthClientFactory creates the client used for the requests of a probe target
+type AuthClientFactory func(target string, token string) authHttp.AuthHttpDoer
+
+type probeTarget struct {
+	token  string
+	client *RemoClient
+}
+
+// ProbeHandler serves the metrics of the account chosen by the target query parameter,
+// similar to the /probe endpoint of the blackbox_exporter.
+// Each target has its own RemoClient so the cache is kept between the probes.
+// The counters of the requests to the remo API are kept between the probes as well.
+type ProbeHandler struct {
+	config        *config.Config
+	tokens        TokenLookup
+	newAuthClient AuthClientFactory
+	self          *selfMetrics
+
+	mu      sync.Mutex
+	targets map[string]*probeTarget
+}
+
+// NewProbeHandler returns an initialized ProbeHandler
+func NewProbeHandler(config *config.Config, tokens TokenLookup, newAuthClient AuthClientFactory) *ProbeHandler {
+	return &ProbeHandler{
+		config:        config,
+		tokens:        tokens,
+		newAuthClient: newAuthClient,
+		self:          newSelfMetrics(namespaceOf(config)),
+		targets:       map[string]*probeTarget{},
+	}
+}
+
+func (h *ProbeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.client(target)
+	if errors.Is(err, config.ErrUnknownTarget) {
+		http.Error(w, "unknown target: "+target, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to create remo client for target %s: %v", target, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e, err := NewAccountsExporter(h.getConfig(), []AccountGatherer{{Name: target, Client: client}}, withSelfMetrics(h.self))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	NewHandler(e).ServeHTTP(w, r)
+}
+
+// SetConfig applies a reloaded config to the handler and the clients of the targets
+func (h *ProbeHandler) SetConfig(c *config.Config) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.config = c
+	for _, t := range h.targets {
+		t.client.ApplyConfig(c)
+	}
+}
+
+func (h *ProbeHandler) getConfig() *config.Config {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.config
+}
+
+// client returns the cached RemoClient of the target. A new client is created if the token changed.
+func (h *ProbeHandler) client(target string) (*RemoClient, error) {
+	token, err := h.tokens.Lookup(target)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if t, ok := h.targets[target]; ok && t.token == token {
+		return t.client, nil
+	}
+
+	account := &config.Account{
+		Name:       target,
+		OAuthToken: token,
+		APIBaseURL: h.config.APIBaseURL,
+	}
+	client, err := NewAccountRemoClient(h.config, account, h.newAuthClient(target, token))
+	if err != nil {
+		return nil, err
+	}
+	h.targets[target] = &probeTarget{token: token, client: client}
+	return client, nil
+}
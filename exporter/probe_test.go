@@ -0,0 +1,97 @@
+package exporter_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/kenfdev/remo-exporter/config"
+	. "github.com/kenfdev/remo-exporter/exporter"
+	authHttp "github.com/kenfdev/remo-exporter/http"
+	"github.com/kenfdev/remo-exporter/mocks"
+)
+
+type staticTokens map[string]string
+
+func (t staticTokens) Lookup(target string) (string, error) {
+	token, ok := t[target]
+	if !ok {
+		return "", config.ErrUnknownTarget
+	}
+	return token, nil
+}
+
+var _ = Describe("ProbeHandler", func() {
+	var (
+		mockCtrl *gomock.Controller
+		api      *httptest.Server
+		requests map[string]int
+		handler  *ProbeHandler
+	)
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		requests = map[string]int{}
+		api = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.Header.Get("Authorization")
+			requests[token+" "+r.URL.Path]++
+			switch r.URL.Path {
+			case "/1/devices":
+				name := "home_remo"
+				if token == "Bearer office_token" {
+					name = "office_remo"
+				}
+				io.WriteString(w, `[{"name": "`+name+`", "id": "some_id", "newest_events": {"te": {"val": 20}}}]`)
+			default:
+				io.WriteString(w, `[]`)
+			}
+		}))
+
+		c, _ := config.NewConfig(mocks.NewMockReader(mockCtrl))
+		c.APIBaseURL = api.URL
+		tokens := staticTokens{"home": "home_token", "office": "office_token"}
+		handler = NewProbeHandler(c, tokens, func(target string, token string) authHttp.AuthHttpDoer {
+			return authHttp.NewAuthHttpClient(token)
+		})
+	})
+	AfterEach(func() {
+		api.Close()
+		mockCtrl.Finish()
+	})
+
+	probe := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/probe"+query, nil))
+		return w
+	}
+
+	It("should serve the metrics of the target", func() {
+		Expect(probe("?target=home").Code).To(Equal(http.StatusOK))
+
+		w := probe("?target=office")
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring(`remo_temperature{account="office",id="some_id",name="office_remo"} 20`))
+		Expect(w.Body.String()).NotTo(ContainSubstring(`account="home"`))
+	})
+
+	It("should keep the cache of each target", func() {
+		Expect(probe("?target=home").Code).To(Equal(http.StatusOK))
+		Expect(probe("?target=office").Code).To(Equal(http.StatusOK))
+		Expect(probe("?target=home").Code).To(Equal(http.StatusOK))
+
+		Expect(requests["Bearer home_token /1/devices"]).To(Equal(1))
+		Expect(requests["Bearer office_token /1/devices"]).To(Equal(1))
+	})
+
+	It("should fail without a target", func() {
+		Expect(probe("").Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should fail with an unknown target", func() {
+		Expect(probe("?target=shop").Code).To(Equal(http.StatusNotFound))
+	})
+})
@@ -1,193 +1,394 @@
 package exporter
 
 import (
-	"encoding/json"
-	"io/ioutil"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
-	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/kenfdev/remo-exporter/config"
 	authHttp "github.com/kenfdev/remo-exporter/http"
 	"github.com/kenfdev/remo-exporter/log"
+	"github.com/kenfdev/remo-exporter/remo"
 	"github.com/kenfdev/remo-exporter/types"
 )
 
 const (
 	maxServicesAPI = 10
+	// autoSkipAppliancesSeconds is how long the appliances are cached in auto mode
+	// when no ECHONET Lite appliance was found
+	autoSkipAppliancesSeconds = 24 * 60 * 60
 )
 
+// ErrAuthBackoff is returned instead of requesting the Remo API while the oauth token is invalid
+// and the retry period hasn't passed yet
+var ErrAuthBackoff = errors.New("the oauth token is invalid. Waiting before retrying")
+
 // RemoGatherer gathers stats from the remo api
 type RemoGatherer interface {
 	GetDevices() (*types.GetDevicesResult, error)
 	GetAppliances() (*types.GetAppliancesResult, error)
 }
 
+// ContextGatherer is implemented by RemoGatherers whose requests are cancelled with the scrape
+type ContextGatherer interface {
+	GetDevicesContext(ctx context.Context) (*types.GetDevicesResult, error)
+	GetAppliancesContext(ctx context.Context) (*types.GetAppliancesResult, error)
+}
+
 type DevicesMetrics struct {
 	StatusCode int
 	Meta       *types.Meta
 	Devices    []*types.Device
+	FetchedAt  time.Time
 }
 
 type AppliancesMetrics struct {
 	StatusCode int
 	Meta       *types.Meta
 	Appliances []*types.Appliance
+	FetchedAt  time.Time
 }
 
-// RemoClient is a http client who requests resources from the Remo API
+// RemoClient caches the devices and appliances requested from the Remo API with a remo.Client.
+// It is safe for concurrent use.
 type RemoClient struct {
-	authClient                         authHttp.AuthHttpDoer
-	baseURL                            string
-	oauthToken                         string
+	// mu guards the cache and the auth state. It isn't held during requests to the Remo API.
+	mu sync.Mutex
+	// devicesFetch and appliancesFetch let one request per endpoint run at a time
+	devicesFetch                       sync.Mutex
+	appliancesFetch                    sync.Mutex
+	api                                *remo.Client
 	cachedDevicesMetrics               *DevicesMetrics
 	cachedAppliancesMetrics            *AppliancesMetrics
-	cacheInvalidationSeconds           int
+	devicesCacheInvalidationSeconds    int
+	appliancesCacheInvalidationSeconds int
+	appliancesMode                     string
+	schemaDrift                        *schemaDriftDetector
 	cacheDevicesExpirationTimestamp    int
 	cacheAppliancesExpirationTimestamp int
+	authRetrySeconds                   int
+	authInvalid                        bool
+	authRetryTimestamp                 int
+	now                                func() time.Time
+	logger                             log.Logger
+}
+
+// NewClient returns a RemoClient caching the results of api configured by the options, e.g.
+//
+//	client := exporter.NewClient(remo.NewClient(token), exporter.WithCachePolicy(policy))
+func NewClient(api *remo.Client, opts ...RemoClientOption) *RemoClient {
+	c := &RemoClient{
+		api:                     api,
+		authRetrySeconds:        5 * 60,
+		cachedDevicesMetrics:    &DevicesMetrics{},
+		cachedAppliancesMetrics: &AppliancesMetrics{},
+		now:                     time.Now,
+		logger:                  log.Default(),
+	}
+	c.setCachePolicy(DefaultCachePolicy)
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // NewRemoClient will return an initialized RemoClient
-func NewRemoClient(config *config.Config, authClient authHttp.AuthHttpDoer) (*RemoClient, error) {
-	return &RemoClient{
-		authClient:               authClient,
-		baseURL:                  config.APIBaseURL,
-		oauthToken:               config.OAuthToken,
-		cacheInvalidationSeconds: config.CacheInvalidationSeconds,
-		cachedDevicesMetrics:     &DevicesMetrics{},
-		cachedAppliancesMetrics:  &AppliancesMetrics{},
-	}, nil
-}
-
-func getMetaStats(header http.Header) *types.Meta {
-	limitStr := header.Get("X-Rate-Limit-Limit")
-	limit, err := strconv.ParseFloat(limitStr, 64)
-	if err != nil {
-		log.Errorf("Error parsing X-Rate-Limit-Limit: %s", err.Error())
-		limit = 0
+func NewRemoClient(c *config.Config, authClient authHttp.AuthHttpDoer) (*RemoClient, error) {
+	account := &config.Account{
+		Name:       config.DefaultAccountName,
+		OAuthToken: c.OAuthToken,
+		APIBaseURL: c.APIBaseURL,
 	}
+	return NewAccountRemoClient(c, account, authClient)
+}
 
-	remainingStr := header.Get("X-Rate-Limit-Remaining")
-	remaining, err := strconv.ParseFloat(remainingStr, 64)
-	if err != nil {
-		log.Errorf("Error parsing X-Rate-Limit-Remaining: %s", err.Error())
-		remaining = 0
+// getDoer sends the requests of a remo.Client with an AuthHttpDoer which can only send GET requests
+type getDoer struct {
+	authHttp.AuthHttpDoer
+}
+
+func (d getDoer) Do(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return nil, fmt.Errorf("unsupported method %s", req.Method)
 	}
+	return d.Get(req.URL.String())
+}
 
-	resetStr := header.Get("X-Rate-Limit-Reset")
-	reset, err := strconv.ParseFloat(resetStr, 64)
-	if err != nil {
-		log.Errorf("Error parsing X-Rate-Limit-Reset: %s", err.Error())
-		reset = 0
+// NewAccountRemoClient will return an initialized RemoClient for one of the configured accounts.
+// The authClient must authenticate with the token of the account.
+// It is the same as NewClient with the options set by the config.
+func NewAccountRemoClient(config *config.Config, account *config.Account, authClient authHttp.AuthHttpDoer) (*RemoClient, error) {
+	doer, ok := authClient.(remo.Doer)
+	if !ok {
+		doer = getDoer{authClient}
+	}
+
+	api := remo.NewClient("", remo.WithBaseURL(account.APIBaseURL), remo.WithHTTPClient(doer))
+	return NewClient(api, remoClientOptionsOf(config)...), nil
+}
+
+// ApplyConfig applies the settings of a reloaded config. The cached results are kept
+// unless they would have expired with changed cache periods. The appliances skipped
+// in auto mode stay skipped.
+func (c *RemoClient) ApplyConfig(config *config.Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	devicesSeconds, appliancesSeconds := c.devicesCacheInvalidationSeconds, c.appliancesCacheInvalidationSeconds
+	for _, opt := range remoClientOptionsOf(config) {
+		opt(c)
 	}
 
-	return &types.Meta{
-		RateLimitLimit:     limit,
-		RateLimitRemaining: remaining,
-		RateLimitReset:     reset,
+	if fetchedAt := c.cachedDevicesMetrics.FetchedAt; !fetchedAt.IsZero() && c.devicesCacheInvalidationSeconds != devicesSeconds {
+		c.cacheDevicesExpirationTimestamp = minInt(c.cacheDevicesExpirationTimestamp, int(fetchedAt.Unix())+c.devicesCacheInvalidationSeconds)
+	}
+	if fetchedAt := c.cachedAppliancesMetrics.FetchedAt; !fetchedAt.IsZero() && c.appliancesCacheInvalidationSeconds != appliancesSeconds {
+		skipped := c.cacheAppliancesExpirationTimestamp == int(fetchedAt.Unix())+autoSkipAppliancesSeconds
+		if !skipped {
+			c.cacheAppliancesExpirationTimestamp = minInt(c.cacheAppliancesExpirationTimestamp, int(fetchedAt.Unix())+c.appliancesCacheInvalidationSeconds)
+		}
+	}
+}
+
+// setCachePolicy sets the cache periods. The cached appliances are dropped if the appliances mode changed.
+func (c *RemoClient) setCachePolicy(p CachePolicy) {
+	c.devicesCacheInvalidationSeconds = int(p.Devices / time.Second)
+	c.appliancesCacheInvalidationSeconds = int(p.Appliances / time.Second)
+	if c.appliancesMode != p.AppliancesMode {
+		c.appliancesMode = p.AppliancesMode
+		c.cacheAppliancesExpirationTimestamp = 0
 	}
 }
 
-// GetDevices will get the devices from the Remo API
+func (c *RemoClient) setSchemaDriftDetection(enabled bool) {
+	if !enabled {
+		c.schemaDrift = nil
+	} else if c.schemaDrift == nil {
+		c.schemaDrift = newSchemaDriftDetector()
+	}
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// AuthValid reports whether the last request to the Remo API was authenticated successfully
+func (c *RemoClient) AuthValid() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return !c.authInvalid
+}
+
+// ResetAuthBackoff lets the next request through although the oauth token was invalid,
+// e.g. because a new token was loaded.
+func (c *RemoClient) ResetAuthBackoff() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.authRetryTimestamp = 0
+}
+
+// checkAuthBackoff returns ErrAuthBackoff if the token was rejected recently
+func (c *RemoClient) checkAuthBackoff(now int) error {
+	if c.authInvalid && now < c.authRetryTimestamp {
+		return ErrAuthBackoff
+	}
+	return nil
+}
+
+// updateAuth tracks whether the token was accepted by the Remo API.
+// Requests are slowed down to once every authRetrySeconds while it isn't.
+// Other errors, e.g. 5xx or 429, say nothing about the token and leave the state unchanged.
+func (c *RemoClient) updateAuth(statusCode int, now int) {
+	if statusCode >= 200 && statusCode < 300 {
+		if c.authInvalid {
+			c.logger.Info("The oauth token is valid again")
+		}
+		c.authInvalid = false
+		return
+	}
+	if statusCode != http.StatusUnauthorized {
+		return
+	}
+
+	if !c.authInvalid {
+		c.logger.Errorf("The oauth token was rejected by the remo API. Retrying every %d seconds", c.authRetrySeconds)
+	}
+	c.authInvalid = true
+	c.authRetryTimestamp = now + c.authRetrySeconds
+}
+
+// GetDevices will get the devices from the Remo API.
+// A *types.APIError is returned if the API responds with a non 200 status code.
 func (c *RemoClient) GetDevices() (*types.GetDevicesResult, error) {
-	now := int(time.Now().Unix())
+	return c.GetDevicesContext(context.Background())
+}
+
+// GetDevicesContext is GetDevices whose request is cancelled with ctx.
+// The cache isn't locked while the request is sent, so AuthValid and ApplyConfig don't wait for the Remo API.
+// Concurrent cache misses wait for a single request.
+func (c *RemoClient) GetDevicesContext(ctx context.Context) (*types.GetDevicesResult, error) {
+	c.devicesFetch.Lock()
+	defer c.devicesFetch.Unlock()
 
+	c.mu.Lock()
+	fetchedAt := c.now()
+	now := int(fetchedAt.Unix())
+	if err := c.checkAuthBackoff(now); err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
 	if now < c.cacheDevicesExpirationTimestamp {
-		log.Infof("GetDevices: Returning cache. Cache valid for %d seconds", c.cacheDevicesExpirationTimestamp-now)
+		c.logger.Infof("GetDevices: Returning cache. Cache valid for %d seconds", c.cacheDevicesExpirationTimestamp-now)
 		result := &types.GetDevicesResult{
 			StatusCode: c.cachedDevicesMetrics.StatusCode,
 			Meta:       c.cachedDevicesMetrics.Meta,
 			Devices:    c.cachedDevicesMetrics.Devices,
 			IsCache:    true,
+			FetchedAt:  c.cachedDevicesMetrics.FetchedAt,
 		}
+		c.mu.Unlock()
 		return result, nil
 	}
+	c.mu.Unlock()
+
+	data, resp, err := c.api.GetDevices(ctx)
 
-	url := c.baseURL + "/1/devices"
-	resp, err := c.authClient.Get(url)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if resp != nil {
+		c.updateAuth(resp.StatusCode, now)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
-
-	data := []*types.Device{}
-	if resp.StatusCode == 200 {
-		bodyBytes, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil, err
-		}
-		json.Unmarshal(bodyBytes, &data)
-
-		// only update invalidation time on successful requests
-		c.cacheDevicesExpirationTimestamp = now + c.cacheInvalidationSeconds
-		log.Infof("GetDevices: Fetched data from the remote API. Caching until %d", c.cacheDevicesExpirationTimestamp)
+	size := len(resp.Body)
+	var unknownFields []types.UnknownField
+	if c.schemaDrift != nil {
+		unknownFields = c.schemaDrift.inspect(resp.Body, data, c.logger)
 	}
 
-	meta := getMetaStats(resp.Header)
+	// only update invalidation time on successful requests
+	c.cacheDevicesExpirationTimestamp = now + c.devicesCacheInvalidationSeconds
+	c.logger.Infof("GetDevices: Fetched data from the remote API. Caching until %d", c.cacheDevicesExpirationTimestamp)
+
 	result := &types.GetDevicesResult{
-		StatusCode: resp.StatusCode,
-		Meta:       meta,
-		Devices:    data,
-		IsCache:    false,
+		StatusCode:    resp.StatusCode,
+		Meta:          resp.Meta,
+		Devices:       data,
+		IsCache:       false,
+		FetchedAt:     fetchedAt,
+		Duration:      resp.Duration,
+		ResponseSize:  size,
+		UnknownFields: unknownFields,
 	}
 
 	c.cachedDevicesMetrics.StatusCode = result.StatusCode
 	c.cachedDevicesMetrics.Meta = result.Meta
 	c.cachedDevicesMetrics.Devices = result.Devices
+	c.cachedDevicesMetrics.FetchedAt = result.FetchedAt
 
 	return result, nil
 }
 
+// GetAppliances will get the appliances from the Remo API.
+// A *types.APIError is returned if the API responds with a non 200 status code.
 func (c *RemoClient) GetAppliances() (*types.GetAppliancesResult, error) {
-	now := int(time.Now().Unix())
+	return c.GetAppliancesContext(context.Background())
+}
+
+// GetAppliancesContext is GetAppliances whose request is cancelled with ctx
+// The cache isn't locked while the request is sent like in GetDevicesContext.
+func (c *RemoClient) GetAppliancesContext(ctx context.Context) (*types.GetAppliancesResult, error) {
+	c.appliancesFetch.Lock()
+	defer c.appliancesFetch.Unlock()
+
+	c.mu.Lock()
+	if c.appliancesMode == config.AppliancesModeDisabled {
+		c.mu.Unlock()
+		return &types.GetAppliancesResult{}, nil
+	}
+
+	fetchedAt := c.now()
+	now := int(fetchedAt.Unix())
+	if err := c.checkAuthBackoff(now); err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
 
 	if now < c.cacheAppliancesExpirationTimestamp {
-		log.Infof("GetAppliances: Returning cache. Cache valid for %d seconds", c.cacheAppliancesExpirationTimestamp-now)
+		c.logger.Infof("GetAppliances: Returning cache. Cache valid for %d seconds", c.cacheAppliancesExpirationTimestamp-now)
 		result := &types.GetAppliancesResult{
 			StatusCode: c.cachedAppliancesMetrics.StatusCode,
 			Meta:       c.cachedAppliancesMetrics.Meta,
 			Appliances: c.cachedAppliancesMetrics.Appliances,
 			IsCache:    true,
+			FetchedAt:  c.cachedAppliancesMetrics.FetchedAt,
 		}
+		c.mu.Unlock()
 		return result, nil
 	}
+	c.mu.Unlock()
+
+	data, resp, err := c.api.GetAppliances(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	url := c.baseURL + "/1/appliances"
-	resp, err := c.authClient.Get(url)
+	if resp != nil {
+		c.updateAuth(resp.StatusCode, now)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
-
-	var data []*types.Appliance
-	if resp.StatusCode == 200 {
-		bodyBytes, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil, err
-		}
-		err = json.Unmarshal(bodyBytes, &data)
-		if err != nil {
-			return nil, err
-		}
+	size := len(resp.Body)
+	var unknownFields []types.UnknownField
+	if c.schemaDrift != nil {
+		unknownFields = c.schemaDrift.inspect(resp.Body, data, c.logger)
+	}
 
-		// only update invalidation time on successful requests
-		c.cacheAppliancesExpirationTimestamp = now + c.cacheInvalidationSeconds
-		log.Infof("GetAppliances: Fetched data from the remote API. Caching until %d", c.cacheAppliancesExpirationTimestamp)
+	// only update invalidation time on successful requests
+	c.cacheAppliancesExpirationTimestamp = now + c.appliancesCacheInvalidationSeconds
+	if c.appliancesMode == config.AppliancesModeAuto && !hasEchonetliteAppliance(data) {
+		c.cacheAppliancesExpirationTimestamp = now + autoSkipAppliancesSeconds
 	}
+	c.logger.Infof("GetAppliances: Fetched data from the remote API. Caching until %d", c.cacheAppliancesExpirationTimestamp)
 
-	meta := getMetaStats(resp.Header)
 	result := &types.GetAppliancesResult{
-		StatusCode: resp.StatusCode,
-		Meta:       meta,
-		Appliances: data,
-		IsCache:    false,
+		StatusCode:    resp.StatusCode,
+		Meta:          resp.Meta,
+		Appliances:    data,
+		IsCache:       false,
+		FetchedAt:     fetchedAt,
+		Duration:      resp.Duration,
+		ResponseSize:  size,
+		UnknownFields: unknownFields,
 	}
 
 	c.cachedAppliancesMetrics.StatusCode = result.StatusCode
 	c.cachedAppliancesMetrics.Meta = result.Meta
 	c.cachedAppliancesMetrics.Appliances = result.Appliances
+	c.cachedAppliancesMetrics.FetchedAt = result.FetchedAt
 
 	return result, nil
 }
+
+func hasEchonetliteAppliance(apps []*types.Appliance) bool {
+	for _, app := range apps {
+		if strings.HasPrefix(app.Type, "EL_") {
+			return true
+		}
+	}
+	return false
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
@@ -13,6 +14,9 @@ import (
 	"github.com/kenfdev/remo-exporter/config"
 	. "github.com/kenfdev/remo-exporter/exporter"
 	"github.com/kenfdev/remo-exporter/mocks"
+	"github.com/kenfdev/remo-exporter/remo"
+	"github.com/kenfdev/remo-exporter/remo/remotest"
+	"github.com/kenfdev/remo-exporter/types"
 )
 
 var _ = Describe("Remo", func() {
@@ -86,9 +90,9 @@ var _ = Describe("Remo", func() {
 
 				device := result.Devices[0]
 				Expect(device.Name).To(Equal("Living Remo"))
-				Expect(device.NewestEvents.Humidity.Value).To(Equal(float64(50)))
-				Expect(device.NewestEvents.Illumination.Value).To(Equal(float64(25.2)))
-				Expect(device.NewestEvents.Temperature.Value).To(Equal(float64(27.59)))
+				Expect(device.NewestEvents[types.SensorHumidity].Value).To(Equal(float64(50)))
+				Expect(device.NewestEvents[types.SensorIllumination].Value).To(Equal(float64(25.2)))
+				Expect(device.NewestEvents[types.SensorTemperature].Value).To(Equal(float64(27.59)))
 
 			})
 
@@ -121,6 +125,68 @@ var _ = Describe("Remo", func() {
 
 				Expect(firstResponse.IsCache).To(BeFalse())
 				Expect(secondResponse.IsCache).To(BeTrue())
+				Expect(secondResponse.FetchedAt).To(Equal(firstResponse.FetchedAt))
+			})
+
+			It("should keep the cache when a config is applied", func() {
+				authClient := mocks.NewMockAuthHttpDoer(mockCtrl)
+				newResponse := func() *http.Response {
+					return &http.Response{
+						StatusCode: 200,
+						Body:       ioutil.NopCloser(bytes.NewBufferString(sampleJson)),
+						Header:     make(http.Header, 0),
+					}
+				}
+				authClient.EXPECT().Get(gomock.Any()).Return(newResponse(), nil).Times(1)
+
+				c, _ := config.NewConfig(mockReader)
+				rc, _ := NewRemoClient(c, authClient)
+
+				_, err := rc.GetDevices()
+				Expect(err).Should(BeNil())
+
+				reloaded := *c
+				reloaded.DevicesCacheInvalidationSeconds = 120
+				rc.ApplyConfig(&reloaded)
+				result, err := rc.GetDevices()
+				Expect(err).Should(BeNil())
+				Expect(result.IsCache).To(BeTrue())
+
+				// a shorter cache period expires the cache
+				authClient.EXPECT().Get(gomock.Any()).Return(newResponse(), nil).Times(1)
+				reloaded.DevicesCacheInvalidationSeconds = 0
+				rc.ApplyConfig(&reloaded)
+				result, err = rc.GetDevices()
+				Expect(err).Should(BeNil())
+				Expect(result.IsCache).To(BeFalse())
+			})
+
+			It("should report unknown fields if schema drift detection is enabled", func() {
+				authClient := mocks.NewMockAuthHttpDoer(mockCtrl)
+
+				response := &http.Response{
+					StatusCode: 200,
+					Body: ioutil.NopCloser(bytes.NewBufferString(`[{
+						"id": "some_id",
+						"mac_address": "00:00:00:00:00:00",
+						"users": [{"id": "some_user_id", "nickname": "John Doe", "superuser": true}],
+						"newest_events": {"te": {"val": 27.59, "created_at": "2018-01-05T00:00:00Z", "accuracy": 0.1}}
+					}]`)),
+				}
+
+				authClient.EXPECT().Get(gomock.Any()).Return(response, nil)
+
+				c, _ := config.NewConfig(mockReader)
+				c.SchemaDriftDetection = true
+
+				rc, _ := NewRemoClient(c, authClient)
+
+				result, err := rc.GetDevices()
+				Expect(err).Should(BeNil())
+				Expect(result.UnknownFields).To(ConsistOf(
+					types.UnknownField{Object: "device", Field: "mac_address"},
+					types.UnknownField{Object: "sensor_value", Field: "accuracy"},
+				))
 			})
 
 			It("should fetch new data if the cache is invalidated", func() {
@@ -141,7 +207,7 @@ var _ = Describe("Remo", func() {
 				)
 
 				c, _ := config.NewConfig(mockReader)
-				c.CacheInvalidationSeconds = 0 // invalidate the cache immediately
+				c.DevicesCacheInvalidationSeconds = 0 // invalidate the cache immediately
 
 				rc, _ := NewRemoClient(c, authClient)
 
@@ -169,13 +235,15 @@ var _ = Describe("Remo", func() {
 				Expect(response).Should(BeNil())
 				Expect(err).Should(Equal(expectedError))
 			})
-			It("should return stats even though the response status code isn't 200", func() {
+			It("should return stats in an APIError if the response status code isn't 200", func() {
 				authClient := mocks.NewMockAuthHttpDoer(mockCtrl)
 
 				response := &http.Response{
 					StatusCode: 401,
-					Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+					Body:       ioutil.NopCloser(bytes.NewBufferString(`{"code": 401001, "message": "Unauthorized"}`)),
+					Header:     make(http.Header, 0),
 				}
+				response.Header.Set("X-Rate-Limit-Remaining", "29")
 
 				authClient.EXPECT().Get(gomock.Any()).Return(response, nil).Times(1)
 
@@ -184,9 +252,130 @@ var _ = Describe("Remo", func() {
 				rc, _ := NewRemoClient(c, authClient)
 
 				result, err := rc.GetDevices()
-				Expect(err).Should(BeNil())
-				Expect(result.StatusCode).Should(Equal(response.StatusCode))
+				Expect(result).Should(BeNil())
+
+				var apiErr *types.APIError
+				Expect(errors.As(err, &apiErr)).Should(BeTrue())
+				Expect(apiErr.StatusCode).Should(Equal(response.StatusCode))
+				Expect(apiErr.Code).Should(Equal(401001))
+				Expect(apiErr.Message).Should(Equal("Unauthorized"))
+				Expect(apiErr.Meta.RateLimitRemaining).Should(Equal(float64(29)))
+			})
+			It("should keep an error body which isn't JSON as the message", func() {
+				authClient := mocks.NewMockAuthHttpDoer(mockCtrl)
+
+				response := &http.Response{
+					StatusCode: 502,
+					Body:       ioutil.NopCloser(bytes.NewBufferString("Bad Gateway\n")),
+				}
+
+				authClient.EXPECT().Get(gomock.Any()).Return(response, nil).Times(1)
+
+				c, _ := config.NewConfig(mockReader)
+
+				rc, _ := NewRemoClient(c, authClient)
+
+				_, err := rc.GetDevices()
+
+				var apiErr *types.APIError
+				Expect(errors.As(err, &apiErr)).Should(BeTrue())
+				Expect(apiErr.StatusCode).Should(Equal(response.StatusCode))
+				Expect(apiErr.Code).Should(BeZero())
+				Expect(apiErr.Message).Should(Equal("Bad Gateway"))
+			})
+			It("should return an error if the response can't be decoded", func() {
+				authClient := mocks.NewMockAuthHttpDoer(mockCtrl)
+
+				response := &http.Response{
+					StatusCode: 200,
+					Body:       ioutil.NopCloser(bytes.NewBufferString("{")),
+				}
+
+				authClient.EXPECT().Get(gomock.Any()).Return(response, nil).Times(1)
+
+				c, _ := config.NewConfig(mockReader)
+
+				rc, _ := NewRemoClient(c, authClient)
+
+				result, err := rc.GetDevices()
+				Expect(result).Should(BeNil())
+				Expect(err).ShouldNot(BeNil())
+			})
+		})
+		Context("invalid oauth token", func() {
+			unauthorized := func() *http.Response {
+				return &http.Response{
+					StatusCode: 401,
+					Body:       ioutil.NopCloser(bytes.NewBufferString(`{"code": 401001, "message": "Unauthorized"}`)),
+					Header:     make(http.Header, 0),
+				}
+			}
+
+			It("should back off until the retry period passes", func() {
+				authClient := mocks.NewMockAuthHttpDoer(mockCtrl)
+				authClient.EXPECT().Get(gomock.Any()).Return(unauthorized(), nil).Times(1)
+
+				c, _ := config.NewConfig(mockReader)
+				rc, _ := NewRemoClient(c, authClient)
+				Expect(rc.AuthValid()).Should(BeTrue())
+
+				_, err := rc.GetDevices()
+				var apiErr *types.APIError
+				Expect(errors.As(err, &apiErr)).Should(BeTrue())
+				Expect(rc.AuthValid()).Should(BeFalse())
+
+				_, err = rc.GetDevices()
+				Expect(err).Should(Equal(ErrAuthBackoff))
+				_, err = rc.GetAppliances()
+				Expect(err).Should(Equal(ErrAuthBackoff))
+			})
+			It("should stay invalid if the API fails for another reason", func() {
+				authClient := mocks.NewMockAuthHttpDoer(mockCtrl)
+				unavailable := &http.Response{
+					StatusCode: 503,
+					Body:       ioutil.NopCloser(bytes.NewBufferString(`{"code": 503001, "message": "Service Unavailable"}`)),
+					Header:     make(http.Header, 0),
+				}
+				gomock.InOrder(
+					authClient.EXPECT().Get(gomock.Any()).Return(unauthorized(), nil),
+					authClient.EXPECT().Get(gomock.Any()).Return(unavailable, nil),
+				)
+
+				c, _ := config.NewConfig(mockReader)
+				rc, _ := NewRemoClient(c, authClient)
+
+				_, err := rc.GetDevices()
+				Expect(err).ShouldNot(BeNil())
+
+				rc.ResetAuthBackoff()
+				_, err = rc.GetDevices()
+				var apiErr *types.APIError
+				Expect(errors.As(err, &apiErr)).Should(BeTrue())
+				Expect(apiErr.StatusCode).Should(Equal(503))
+				Expect(rc.AuthValid()).Should(BeFalse())
+			})
+			It("should be valid again after a successful request", func() {
+				authClient := mocks.NewMockAuthHttpDoer(mockCtrl)
+				response := &http.Response{
+					StatusCode: 200,
+					Body:       ioutil.NopCloser(bytes.NewBufferString(sampleJson)),
+				}
+				gomock.InOrder(
+					authClient.EXPECT().Get(gomock.Any()).Return(unauthorized(), nil),
+					authClient.EXPECT().Get(gomock.Any()).Return(response, nil),
+				)
 
+				c, _ := config.NewConfig(mockReader)
+				rc, _ := NewRemoClient(c, authClient)
+
+				_, err := rc.GetDevices()
+				Expect(err).ShouldNot(BeNil())
+
+				rc.ResetAuthBackoff()
+				result, err := rc.GetDevices()
+				Expect(err).Should(BeNil())
+				Expect(result.Devices).Should(HaveLen(1))
+				Expect(rc.AuthValid()).Should(BeTrue())
 			})
 		})
 	})
@@ -303,5 +492,213 @@ var _ = Describe("Remo", func() {
 				Expect(app.SmartMeter.EchonetliteProperties[2].Val).To(Equal("50851"))
 			})
 		})
+		Context("appliances mode", func() {
+			It("should not request the appliances if disabled", func() {
+				authClient := mocks.NewMockAuthHttpDoer(mockCtrl)
+
+				authClient.EXPECT().Get(gomock.Any()).Times(0)
+
+				c, _ := config.NewConfig(mockReader)
+				c.AppliancesMode = config.AppliancesModeDisabled
+
+				rc, _ := NewRemoClient(c, authClient)
+
+				result, err := rc.GetAppliances()
+				Expect(err).Should(BeNil())
+				Expect(result.Appliances).Should(BeEmpty())
+				Expect(result.StatusCode).Should(BeZero())
+			})
+			It("should keep the cache if there are no ECHONET Lite appliances in auto mode", func() {
+				authClient := mocks.NewMockAuthHttpDoer(mockCtrl)
+
+				response := &http.Response{
+					StatusCode: 200,
+					Body:       ioutil.NopCloser(bytes.NewBufferString(`[{"id": "some_id", "type": "AC"}]`)),
+				}
+
+				authClient.EXPECT().Get(gomock.Any()).Return(response, nil).Times(1)
+
+				c, _ := config.NewConfig(mockReader)
+				c.AppliancesMode = config.AppliancesModeAuto
+				c.AppliancesCacheInvalidationSeconds = 0
+
+				rc, _ := NewRemoClient(c, authClient)
+
+				firstResponse, err := rc.GetAppliances()
+				Expect(err).Should(BeNil())
+
+				secondResponse, err := rc.GetAppliances()
+				Expect(err).Should(BeNil())
+
+				Expect(firstResponse.IsCache).To(BeFalse())
+				Expect(secondResponse.IsCache).To(BeTrue())
+			})
+			It("should fetch new data if there are ECHONET Lite appliances in auto mode", func() {
+				authClient := mocks.NewMockAuthHttpDoer(mockCtrl)
+
+				response1 := &http.Response{
+					StatusCode: 200,
+					Body:       ioutil.NopCloser(bytes.NewBufferString(sampleJson)),
+				}
+				response2 := &http.Response{
+					StatusCode: 200,
+					Body:       ioutil.NopCloser(bytes.NewBufferString(sampleJson)),
+				}
+
+				gomock.InOrder(
+					authClient.EXPECT().Get(gomock.Any()).Return(response1, nil).Times(1),
+					authClient.EXPECT().Get(gomock.Any()).Return(response2, nil).Times(1),
+				)
+
+				c, _ := config.NewConfig(mockReader)
+				c.AppliancesMode = config.AppliancesModeAuto
+				c.AppliancesCacheInvalidationSeconds = 0
+
+				rc, _ := NewRemoClient(c, authClient)
+
+				_, err := rc.GetAppliances()
+				Expect(err).Should(BeNil())
+
+				secondResponse, err := rc.GetAppliances()
+				Expect(err).Should(BeNil())
+				Expect(secondResponse.IsCache).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("NewClient", func() {
+		var (
+			server *remotest.Server
+			now    time.Time
+			rc     *RemoClient
+		)
+		BeforeEach(func() {
+			server = remotest.NewServer("dummy_token")
+			server.SetDevices([]*types.Device{{ID: "living_id", Name: "Living"}})
+			now = time.Unix(1600000000, 0)
+			rc = NewClient(remo.NewClient("dummy_token", remo.WithBaseURL(server.URL)),
+				WithCachePolicy(CachePolicy{Devices: time.Minute, Appliances: time.Hour, AppliancesMode: config.AppliancesModeEnabled}),
+				WithClientClock(func() time.Time { return now }),
+			)
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should expire the cache by the clock", func() {
+			result, err := rc.GetDevices()
+			Expect(err).Should(BeNil())
+			Expect(result.IsCache).To(BeFalse())
+			Expect(result.FetchedAt).To(Equal(now))
+			Expect(result.Meta.RateLimitLimit).To(BeEquivalentTo(remotest.DefaultRateLimit))
+
+			now = now.Add(59 * time.Second)
+			result, err = rc.GetDevices()
+			Expect(err).Should(BeNil())
+			Expect(result.IsCache).To(BeTrue())
+
+			now = now.Add(time.Second)
+			result, err = rc.GetDevices()
+			Expect(err).Should(BeNil())
+			Expect(result.IsCache).To(BeFalse())
+			Expect(server.Requests()).To(Equal(2))
+		})
+
+		It("should keep the appliances skipped in auto mode when a config is applied", func() {
+			server.SetAppliances([]*types.Appliance{{ID: "aircon_id", Type: "AC", Nickname: "Aircon"}})
+			c := &config.Config{
+				DevicesCacheInvalidationSeconds:    60,
+				AppliancesCacheInvalidationSeconds: 60,
+				AppliancesMode:                     config.AppliancesModeAuto,
+				AuthRetrySeconds:                   300,
+			}
+			rc.ApplyConfig(c)
+
+			result, err := rc.GetAppliances()
+			Expect(err).Should(BeNil())
+			Expect(result.IsCache).To(BeFalse())
+
+			reloaded := *c
+			rc.ApplyConfig(&reloaded)
+			reloaded.AppliancesCacheInvalidationSeconds = 30
+			rc.ApplyConfig(&reloaded)
+
+			now = now.Add(time.Hour)
+			result, err = rc.GetAppliances()
+			Expect(err).Should(BeNil())
+			Expect(result.IsCache).To(BeTrue())
+			Expect(server.Requests()).To(Equal(1))
+		})
+
+		It("should expire the cache only if the cache period changed", func() {
+			_, err := rc.GetDevices()
+			Expect(err).Should(BeNil())
+			c := &config.Config{
+				DevicesCacheInvalidationSeconds:    60,
+				AppliancesCacheInvalidationSeconds: 3600,
+				AppliancesMode:                     config.AppliancesModeEnabled,
+				AuthRetrySeconds:                   300,
+			}
+
+			now = now.Add(30 * time.Second)
+			rc.ApplyConfig(c)
+			result, err := rc.GetDevices()
+			Expect(err).Should(BeNil())
+			Expect(result.IsCache).To(BeTrue())
+
+			reloaded := *c
+			reloaded.DevicesCacheInvalidationSeconds = 10
+			rc.ApplyConfig(&reloaded)
+			result, err = rc.GetDevices()
+			Expect(err).Should(BeNil())
+			Expect(result.IsCache).To(BeFalse())
+		})
+
+		It("should report the auth state while a request is in flight", func() {
+			started, release := make(chan struct{}), make(chan struct{})
+			blocking := NewClient(remo.NewClient("dummy_token", remo.WithBaseURL(server.URL), remo.WithHTTPClient(doerFunc(func(req *http.Request) (*http.Response, error) {
+				close(started)
+				<-release
+				return http.DefaultClient.Do(req)
+			}))))
+			done := make(chan error, 1)
+			go func() {
+				_, err := blocking.GetDevices()
+				done <- err
+			}()
+			<-started
+
+			valid := make(chan bool, 1)
+			go func() { valid <- blocking.AuthValid() }()
+			Eventually(valid).Should(Receive(BeTrue()))
+
+			close(release)
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("should pause the requests by the clock after the token was rejected", func() {
+			server.FailNext(http.StatusUnauthorized, 401001, "Unauthorized")
+
+			_, err := rc.GetDevices()
+			var apiErr *types.APIError
+			Expect(errors.As(err, &apiErr)).To(BeTrue())
+			Expect(rc.AuthValid()).To(BeFalse())
+
+			now = now.Add(4 * time.Minute)
+			_, err = rc.GetDevices()
+			Expect(err).To(Equal(ErrAuthBackoff))
+
+			now = now.Add(time.Minute)
+			_, err = rc.GetDevices()
+			Expect(err).Should(BeNil())
+			Expect(rc.AuthValid()).To(BeTrue())
+		})
 	})
 })
+
+// doerFunc sends the requests of a remo.Client with a function
+type doerFunc func(req *http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
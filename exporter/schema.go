@@ -0,0 +1,128 @@
+package exporter
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/kenfdev/remo-exporter/log"
+	"github.com/kenfdev/remo-exporter/types"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaDriftDetector finds JSON keys in the Remo API responses which are unknown to the types package
+type schemaDriftDetector struct {
+	mu   sync.Mutex
+	seen map[types.UnknownField]struct{}
+}
+
+func newSchemaDriftDetector() *schemaDriftDetector {
+	return &schemaDriftDetector{
+		seen: map[types.UnknownField]struct{}{},
+	}
+}
+
+// inspect returns the unknown fields found in body when decoded into v.
+// Each unknown field is logged with logger the first time it is found.
+func (d *schemaDriftDetector) inspect(body []byte, v interface{}, logger log.Logger) []types.UnknownField {
+	var raw interface{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil
+	}
+
+	found := map[types.UnknownField]struct{}{}
+	walkUnknownFields(reflect.TypeOf(v), raw, found)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fields := make([]types.UnknownField, 0, len(found))
+	for f := range found {
+		if _, ok := d.seen[f]; !ok {
+			d.seen[f] = struct{}{}
+			logger.Infof("Schema drift: unknown field %q found in %s object of the remo API response", f.Field, f.Object)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func walkUnknownFields(t reflect.Type, v interface{}, found map[types.UnknownField]struct{}) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		values, ok := v.([]interface{})
+		if !ok {
+			return
+		}
+		for _, e := range values {
+			walkUnknownFields(t.Elem(), e, found)
+		}
+	case reflect.Map:
+		values, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for _, e := range values {
+			walkUnknownFields(t.Elem(), e, found)
+		}
+	case reflect.Struct:
+		if t == timeType {
+			return
+		}
+		values, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		fields := jsonFields(t)
+		for key, e := range values {
+			ft, ok := fields[key]
+			if !ok {
+				found[types.UnknownField{Object: objectName(t), Field: key}] = struct{}{}
+				continue
+			}
+			walkUnknownFields(ft, e, found)
+		}
+	}
+}
+
+// jsonFields maps the JSON keys of a struct to the types of their fields
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			tagName := strings.Split(tag, ",")[0]
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// objectName converts a type name like SensorValue to sensor_value
+func objectName(t reflect.Type) string {
+	var b strings.Builder
+	for i, r := range t.Name() {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -25,6 +25,20 @@ type EnergyInfo struct {
 	MeasuredInstantaneous int
 }
 
+// kilowattHours converts a raw cumulative electric energy value to kWh.
+// The coefficient is 1 and the unit is 1 kWh if the smart meter doesn't report them.
+func (info *EnergyInfo) kilowattHours(raw int) float64 {
+	coefficient := info.Coefficient
+	if coefficient == 0 {
+		coefficient = 1
+	}
+	unit := info.EnergyUnit
+	if unit == 0 {
+		unit = 1
+	}
+	return float64(raw) * float64(coefficient) * unit
+}
+
 func getSmartMeters(apps []*types.Appliance) []*types.Appliance {
 	smartMeters := make([]*types.Appliance, 0)
 	for _, app := range apps {
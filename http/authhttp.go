@@ -2,6 +2,7 @@ package http
 
 import (
 	"net/http"
+	"sync"
 )
 
 type AuthHttpDoer interface {
@@ -9,24 +10,77 @@ type AuthHttpDoer interface {
 }
 
 type AuthHttpClient struct {
-	token  string
-	client *http.Client
+	client         *http.Client
+	token          *Token
+	mu             sync.Mutex
+	onUnauthorized func() bool
 }
 
-func NewAuthHttpClient(token string) *AuthHttpClient {
+// NewAuthHttpClient returns a client which sends every request through the Auth middleware
+// followed by the given middlewares.
+func NewAuthHttpClient(token string, middlewares ...Middleware) *AuthHttpClient {
+	return NewAuthHttpClientWithTransport(token, http.DefaultTransport, middlewares...)
+}
+
+// NewAuthHttpClientWithTransport is like NewAuthHttpClient but sends the requests with transport
+func NewAuthHttpClientWithTransport(token string, transport http.RoundTripper, middlewares ...Middleware) *AuthHttpClient {
+	t := NewToken(token)
+	chain := append([]Middleware{AuthToken(t)}, middlewares...)
 	return &AuthHttpClient{
-		token:  token,
-		client: &http.Client{},
+		client: &http.Client{
+			Transport: Chain(transport, chain...),
+		},
+		token: t,
 	}
 }
 
+// SetToken replaces the token used for the following requests
+func (c *AuthHttpClient) SetToken(token string) {
+	c.token.Set(token)
+}
+
+// OnUnauthorized sets a function called when a request is responded with 401.
+// The request is sent once more if the function returns true, e.g. after it replaced the token.
+func (c *AuthHttpClient) OnUnauthorized(f func() bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onUnauthorized = f
+}
+
 func (c *AuthHttpClient) Get(url string) (*http.Response, error) {
 	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Add("Authorization", "Bearer "+c.token)
-
 	if err != nil {
 		return nil, err
 	}
 
-	return c.client.Do(req)
+	return c.Do(req)
+}
+
+// Do sends the request with the token. A request with a body is only sent once more after a 401
+// if the body can be read again with req.GetBody.
+func (c *AuthHttpClient) Do(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	onUnauthorized := c.onUnauthorized
+	c.mu.Unlock()
+
+	resp, err := c.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || onUnauthorized == nil {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	if !onUnauthorized() {
+		return resp, nil
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	return c.client.Do(retry)
 }
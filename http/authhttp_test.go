@@ -1,8 +1,10 @@
 package http_test
 
 import (
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	authhttp "github.com/kenfdev/remo-exporter/http"
@@ -32,3 +34,81 @@ func TestAuthHttpClient(t *testing.T) {
 		t.Fatalf("unexpected status code want=%d got=%d", want, got)
 	}
 }
+
+func TestAuthHttpClientReloadsTokenOnUnauthorized(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer new_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+
+	c := authhttp.NewAuthHttpClient("old_token")
+	reloads := 0
+	c.OnUnauthorized(func() bool {
+		reloads++
+		c.SetToken("new_token")
+		return true
+	})
+
+	resp, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if want, got := http.StatusOK, resp.StatusCode; want != got {
+		t.Fatalf("unexpected status code want=%d got=%d", want, got)
+	}
+	if want, got := 1, reloads; want != got {
+		t.Fatalf("unexpected number of reloads want=%d got=%d", want, got)
+	}
+
+	// the new token is kept for the following requests
+	resp, err = c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if want, got := 1, reloads; want != got {
+		t.Fatalf("unexpected number of reloads want=%d got=%d", want, got)
+	}
+}
+
+func TestAuthHttpClientResendsBodyOnUnauthorized(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer new_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if want, got := "button=on", string(body); want != got {
+			t.Errorf("unexpected body want=%s got=%s", want, got)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+
+	c := authhttp.NewAuthHttpClient("old_token")
+	c.OnUnauthorized(func() bool {
+		c.SetToken("new_token")
+		return true
+	})
+
+	req, err := http.NewRequest("POST", ts.URL, strings.NewReader("button=on"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if want, got := http.StatusOK, resp.StatusCode; want != got {
+		t.Fatalf("unexpected status code want=%d got=%d", want, got)
+	}
+}
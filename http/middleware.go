@@ -0,0 +1,126 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/kenfdev/remo-exporter/log"
+)
+
+// Middleware wraps a http.RoundTripper to add behavior to every outbound request
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is an adapter to use an ordinary function as a http.RoundTripper
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req)
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Chain wraps rt with the middlewares. The first middleware is the outermost one.
+func Chain(rt http.RoundTripper, middlewares ...Middleware) http.RoundTripper {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		rt = middlewares[i](rt)
+	}
+	return rt
+}
+
+// Auth sets the bearer token to the Authorization header of every request
+func Auth(token string) Middleware {
+	return AuthToken(NewToken(token))
+}
+
+// AuthToken is like Auth but uses the current value of a replaceable token
+func AuthToken(token *Token) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			// a RoundTripper must not modify the original request
+			req = req.Clone(req.Context())
+			req.Header.Set("Authorization", "Bearer "+token.Get())
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// Metrics counts the requests by code and method and observes their latency by method
+func Metrics(requests *prometheus.CounterVec, duration prometheus.ObserverVec) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return promhttp.InstrumentRoundTripperCounter(requests,
+			promhttp.InstrumentRoundTripperDuration(duration, next))
+	}
+}
+
+// Logging logs every request and response at debug level. The Authorization header is redacted.
+func Logging() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			log.Debugf("HTTP request: %s %s headers=%v", req.Method, req.URL, redactHeader(req.Header))
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				log.Debugf("HTTP request failed: %s %s after %s: %v", req.Method, req.URL, time.Since(start), err)
+				return nil, err
+			}
+			log.Debugf("HTTP response: %s %s status=%d after %s", req.Method, req.URL, resp.StatusCode, time.Since(start))
+			return resp, nil
+		})
+	}
+}
+
+func redactHeader(header http.Header) http.Header {
+	redacted := header.Clone()
+	if v := redacted.Get("Authorization"); v != "" {
+		scheme := strings.SplitN(v, " ", 2)[0]
+		redacted.Set("Authorization", scheme+" <redacted>")
+	}
+	return redacted
+}
+
+// Retry retries requests which failed with a network error or a 5xx status code
+// up to maxRetries times. The wait between the attempts starts at backoff and doubles every attempt.
+// Only idempotent requests without a body are retried.
+func Retry(maxRetries int, backoff time.Duration) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Body != nil && req.Body != http.NoBody {
+				return next.RoundTrip(req)
+			}
+			if req.Method != http.MethodGet && req.Method != http.MethodHead {
+				return next.RoundTrip(req)
+			}
+
+			wait := backoff
+			for attempt := 0; ; attempt++ {
+				resp, err := next.RoundTrip(req)
+				if attempt >= maxRetries || !shouldRetry(resp, err) {
+					return resp, err
+				}
+				if err != nil {
+					log.Errorf("Retrying %s %s after %s: %v", req.Method, req.URL, wait, err)
+				} else {
+					log.Errorf("Retrying %s %s after %s: status %d", req.Method, req.URL, wait, resp.StatusCode)
+					resp.Body.Close()
+				}
+
+				select {
+				case <-req.Context().Done():
+					return nil, req.Context().Err()
+				case <-time.After(wait):
+				}
+				wait *= 2
+			}
+		})
+	}
+}
+
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= 500
+}
@@ -0,0 +1,112 @@
+package http_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	authhttp "github.com/kenfdev/remo-exporter/http"
+)
+
+func TestChainOrder(t *testing.T) {
+	calls := []string{}
+	record := func(name string) authhttp.Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return authhttp.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				calls = append(calls, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+	final := authhttp.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		calls = append(calls, "transport")
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+
+	rt := authhttp.Chain(final, record("first"), record("second"))
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"first", "second", "transport"}; !reflect.DeepEqual(want, calls) {
+		t.Fatalf("unexpected call order want=%v got=%v", want, calls)
+	}
+}
+
+func TestCustomMiddlewareSeesAuthHeader(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+
+	var got string
+	custom := func(next http.RoundTripper) http.RoundTripper {
+		return authhttp.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			got = req.Header.Get("Authorization")
+			return next.RoundTrip(req)
+		})
+	}
+
+	c := authhttp.NewAuthHttpClient("dummy_token", custom)
+	resp, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if want := "Bearer dummy_token"; want != got {
+		t.Fatalf("unexpected authz header want=%s got=%s", want, got)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+
+	c := authhttp.NewAuthHttpClient("dummy_token", authhttp.Retry(2, 0))
+	resp, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if want, got := http.StatusOK, resp.StatusCode; want != got {
+		t.Fatalf("unexpected status code want=%d got=%d", want, got)
+	}
+	if want, got := 3, attempts; want != got {
+		t.Fatalf("unexpected number of attempts want=%d got=%d", want, got)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(ts.Close)
+
+	c := authhttp.NewAuthHttpClient("dummy_token", authhttp.Retry(1, 0))
+	resp, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if want, got := http.StatusInternalServerError, resp.StatusCode; want != got {
+		t.Fatalf("unexpected status code want=%d got=%d", want, got)
+	}
+	if want, got := 2, attempts; want != got {
+		t.Fatalf("unexpected number of attempts want=%d got=%d", want, got)
+	}
+}
@@ -0,0 +1,27 @@
+package http
+
+import (
+	"sync/atomic"
+)
+
+// Token holds an oauth token which can be replaced while requests are being sent
+type Token struct {
+	value atomic.Value
+}
+
+// NewToken returns a Token holding token
+func NewToken(token string) *Token {
+	t := &Token{}
+	t.Set(token)
+	return t
+}
+
+// Get returns the current token
+func (t *Token) Get() string {
+	return t.value.Load().(string)
+}
+
+// Set replaces the token atomically
+func (t *Token) Set(token string) {
+	t.value.Store(token)
+}
@@ -0,0 +1,87 @@
+package http_test
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	authhttp "github.com/kenfdev/remo-exporter/http"
+)
+
+func TestTransportCAFile(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	transport, err := authhttp.NewTransport(authhttp.TransportConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authhttp.NewAuthHttpClientWithTransport("dummy_token", transport).Get(ts.URL); err == nil {
+		t.Fatal("expected an error without the CA file")
+	}
+
+	transport, err = authhttp.NewTransport(authhttp.TransportConfig{CAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := authhttp.NewAuthHttpClientWithTransport("dummy_token", transport).Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if want, got := http.StatusOK, resp.StatusCode; want != got {
+		t.Fatalf("unexpected status code want=%d got=%d", want, got)
+	}
+}
+
+func TestTransportProxyURL(t *testing.T) {
+	var gotURL, gotAuth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURL = r.URL.String()
+		gotAuth = r.Header.Get("Proxy-Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(proxy.Close)
+
+	proxyURL := "http://user:pass@" + proxy.Listener.Addr().String()
+	transport, err := authhttp.NewTransport(authhttp.TransportConfig{ProxyURL: proxyURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := authhttp.NewAuthHttpClientWithTransport("dummy_token", transport).Get("http://api.example.com/1/devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if want := "http://api.example.com/1/devices"; want != gotURL {
+		t.Fatalf("unexpected proxied url want=%s got=%s", want, gotURL)
+	}
+	if want := "Basic dXNlcjpwYXNz"; want != gotAuth {
+		t.Fatalf("unexpected proxy authorization want=%s got=%s", want, gotAuth)
+	}
+}
+
+func TestTransportInvalidConfig(t *testing.T) {
+	for name, c := range map[string]authhttp.TransportConfig{
+		"tls version": {MinTLSVersion: "2.0"},
+		"ca file":     {CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		"client cert": {CertFile: "cert.pem"},
+	} {
+		if _, err := authhttp.NewTransport(c); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+// Package listenaddr parses the addresses the exporter listens on. An address is either
+// host:port, e.g. :9352, 127.0.0.1:9352 or [::1]:9352, or a unix socket like unix:/run/remo-exporter.sock.
+package listenaddr
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// unixPrefix marks the address of a unix socket
+const unixPrefix = "unix:"
+
+// Parse returns the network ("tcp" or "unix") and the address to listen on
+func Parse(address string) (network string, addr string, err error) {
+	if strings.HasPrefix(address, unixPrefix) {
+		path := strings.TrimPrefix(address, unixPrefix)
+		if path == "" {
+			return "", "", fmt.Errorf("missing path of unix socket %q", address)
+		}
+		return "unix", path, nil
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return "", "", fmt.Errorf("invalid listen address %q: %w", address, err)
+	}
+	return "tcp", address, nil
+}
+
+// Validate returns an error if address is neither host:port nor unix:<path>
+func Validate(address string) error {
+	_, _, err := Parse(address)
+	return err
+}
@@ -0,0 +1,33 @@
+package listenaddr_test
+
+import (
+	"testing"
+
+	"github.com/kenfdev/remo-exporter/listenaddr"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		address string
+		network string
+		addr    string
+		valid   bool
+	}{
+		{":9352", "tcp", ":9352", true},
+		{"127.0.0.1:9352", "tcp", "127.0.0.1:9352", true},
+		{"[::1]:9352", "tcp", "[::1]:9352", true},
+		{"unix:/run/remo-exporter.sock", "unix", "/run/remo-exporter.sock", true},
+		{"unix:", "", "", false},
+		{"localhost", "", "", false},
+		{"::1:9352", "", "", false},
+	}
+	for _, tt := range tests {
+		network, addr, err := listenaddr.Parse(tt.address)
+		if tt.valid != (err == nil) {
+			t.Fatalf("unexpected error of %q: %v", tt.address, err)
+		}
+		if network != tt.network || addr != tt.addr {
+			t.Fatalf("unexpected result of %q want=%s %s got=%s %s", tt.address, tt.network, tt.addr, network, addr)
+		}
+	}
+}
@@ -6,6 +6,19 @@ import (
 
 var logger = logrus.New()
 
+// Logger logs the messages of the exporter. *logrus.Logger implements it.
+type Logger interface {
+	Info(args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Debugf(format string, args ...interface{})
+}
+
+// Default returns the logger of the package functions
+func Default() Logger {
+	return logger
+}
+
 func Info(args ...interface{}) {
 	logger.Info(args...)
 }
@@ -21,3 +34,23 @@ func Errorf(format string, args ...interface{}) {
 func Fatal(args ...interface{}) {
 	logger.Fatal(args...)
 }
+
+func Debugf(format string, args ...interface{}) {
+	logger.Debugf(format, args...)
+}
+
+// ValidateLevel returns an error if level isn't a valid log level
+func ValidateLevel(level string) error {
+	_, err := logrus.ParseLevel(level)
+	return err
+}
+
+// SetLevel sets the log level. Valid levels are the ones of logrus (e.g. debug, info)
+func SetLevel(level string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	logger.SetLevel(lvl)
+	return nil
+}
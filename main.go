@@ -1,44 +1,148 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/kenfdev/remo-exporter/config"
 	"github.com/kenfdev/remo-exporter/exporter"
 	authHttp "github.com/kenfdev/remo-exporter/http"
 	"github.com/kenfdev/remo-exporter/log"
+	"github.com/kenfdev/remo-exporter/web"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	versioncollector "github.com/prometheus/client_golang/prometheus/collectors/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/version"
 )
 
+// programName is the name of the --version output
+const programName = "remo_exporter"
+
+// tokenExpiryCheckInterval is how often tokens which expire are checked
+const tokenExpiryCheckInterval = 30 * time.Second
+
 func main() {
 
-	log.Info("Starting Nature Remo Exporter")
+	flags := config.RegisterFlags(flag.CommandLine)
+	showVersion := flag.Bool("version", false, "Print the version and exit")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(version.Print(programName))
+		os.Exit(0)
+	}
+
+	log.Infof("Starting Nature Remo Exporter %s", version.Info())
 	r := config.NewFileReader()
-	c, err := config.NewConfig(r)
+	c, err := config.Load(r, flags)
 	if err != nil {
 		log.Errorf("Failed to create config: %v", err)
 		os.Exit(1)
 	}
 
-	authClient := authHttp.NewAuthHttpClient(c.OAuthToken)
+	if err := log.SetLevel(c.LogLevel); err != nil {
+		log.Errorf("Failed to set log level: %v", err)
+		os.Exit(1)
+	}
+
+	clientRequests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: c.Namespace,
+		Name:      "http_client_requests_total",
+		Help:      "The total number of HTTP requests sent to the remo API including retries",
+	}, []string{"account", "code", "method"})
+	clientRequestDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: c.Namespace,
+		Name:      "http_client_request_duration_seconds",
+		Help:      "The latency of HTTP requests sent to the remo API including retries",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"account", "method"})
+	// the metrics of the program are served as part of the self collector group
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		versioncollector.NewCollector(c.Namespace+"_exporter"),
+		clientRequests,
+		clientRequestDuration,
+	)
 
-	rc, err := exporter.NewRemoClient(c, authClient)
+	transport, err := authHttp.NewTransport(authHttp.TransportConfig{
+		ProxyURL:           c.HTTPProxyURL,
+		CAFile:             c.HTTPCAFile,
+		CertFile:           c.HTTPClientCertFile,
+		KeyFile:            c.HTTPClientKeyFile,
+		MinTLSVersion:      c.HTTPTLSMinVersion,
+		InsecureSkipVerify: c.HTTPInsecureSkipVerify,
+	})
 	if err != nil {
-		log.Errorf("Failed to create remo client: %v", err)
+		log.Errorf("Failed to create http transport: %v", err)
 		os.Exit(1)
 	}
+	if c.HTTPInsecureSkipVerify {
+		log.Info("TLS certificate verification of the remo API is disabled")
+	}
 
-	e, err := exporter.NewExporter(c, rc)
+	newAuthClient := func(account string, token string) *authHttp.AuthHttpClient {
+		accountLabel := prometheus.Labels{"account": account}
+		return authHttp.NewAuthHttpClientWithTransport(token, transport,
+			authHttp.Retry(c.HTTPRetries, time.Second),
+			authHttp.Metrics(clientRequests.MustCurryWith(accountLabel), clientRequestDuration.MustCurryWith(accountLabel)),
+			authHttp.Logging(),
+		)
+	}
+
+	rl := newReloader(r, flags, c, newAuthClient)
+	registry.MustRegister(rl.collectors()...)
+	accounts, err := rl.start()
+	if err != nil {
+		log.Errorf("Failed to create remo clients: %v", err)
+		os.Exit(1)
+	}
+
+	e, err := exporter.NewAccountsExporter(c, accounts)
 	if err != nil {
 		log.Errorf("Failed to create exporter: %v", err)
 		os.Exit(1)
 	}
+	rl.exporter = e
+
+	if c.ProbeCredentials != "" {
+		credentials, err := config.NewProbeCredentials(r, c.ProbeCredentials)
+		if err != nil {
+			log.Errorf("Failed to load probe credentials: %v", err)
+			os.Exit(1)
+		}
+		rl.probe = exporter.NewProbeHandler(c, credentials, func(target string, token string) authHttp.AuthHttpDoer {
+			return newAuthClient(target, token)
+		})
+		http.Handle("/probe", rl.probe)
+	}
 
-	prometheus.MustRegister(e)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Info("Received SIGHUP. Reloading the config")
+			rl.reload()
+		}
+	}()
+	http.HandleFunc("/-/reload", rl.handleReload)
 
-	http.Handle(c.MetricsPath, promhttp.Handler())
+	http.Handle(c.MetricsPath, promhttp.InstrumentMetricHandler(registry, exporter.NewHandler(e, registry)))
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := e.Ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("OK"))
+	})
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 		                <head><title>Nature Remo Exporter</title></head>
@@ -50,6 +154,37 @@ func main() {
 		                </html>
 		              `))
 	})
-	log.Infof("Listening on :%s", c.ListenPort)
-	log.Fatal(http.ListenAndServe(":"+c.ListenPort, nil))
+	listeners, err := web.ListenAll(c.ListenAddresses)
+	if err != nil {
+		log.Errorf("Failed to listen: %v", err)
+		os.Exit(1)
+	}
+	server := &http.Server{}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- web.ServeListeners(listeners, server, c.WebConfigFile, log.Default())
+	}()
+
+	term := make(chan os.Signal, 2)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case sig := <-term:
+		log.Infof("Received %s. Shutting down", sig)
+	}
+
+	// a second signal exits right away
+	go func() {
+		sig := <-term
+		log.Errorf("Received %s again. Exiting without waiting for the in-flight requests", sig)
+		os.Exit(1)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), rl.shutdownTimeout())
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Errorf("Failed to finish the in-flight requests: %v", err)
+	}
+	rl.stop()
+	log.Info("Stopped")
 }
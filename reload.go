@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kenfdev/remo-exporter/config"
+	"github.com/kenfdev/remo-exporter/exporter"
+	authHttp "github.com/kenfdev/remo-exporter/http"
+	"github.com/kenfdev/remo-exporter/log"
+)
+
+// accountRunner runs the RemoClient of an account and the watcher of its token
+type accountRunner struct {
+	account    *config.Account
+	authClient *authHttp.AuthHttpClient
+	client     *exporter.RemoClient
+	refresher  *config.TokenRefresher
+	stopWatch  chan struct{}
+}
+
+// reloader builds the RemoClients of the accounts and applies reloaded configs to them.
+// The clients of unchanged accounts are kept so their cache survives a reload.
+type reloader struct {
+	mu            sync.Mutex
+	reader        config.Reader
+	flags         *config.Flags
+	config        *config.Config
+	newAuthClient func(account string, token string) *authHttp.AuthHttpClient
+	exporter      *exporter.Exporter
+	probe         *exporter.ProbeHandler
+	accounts      map[string]*accountRunner
+
+	tokenReloads  *prometheus.CounterVec
+	tokenLastLoad *prometheus.GaugeVec
+	lastSuccess   prometheus.Gauge
+	lastTimestamp prometheus.Gauge
+}
+
+func newReloader(r config.Reader, flags *config.Flags, c *config.Config, newAuthClient func(account string, token string) *authHttp.AuthHttpClient) *reloader {
+	return &reloader{
+		reader:        r,
+		flags:         flags,
+		config:        c,
+		newAuthClient: newAuthClient,
+		accounts:      map[string]*accountRunner{},
+		tokenReloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: c.Namespace,
+			Name:      "token_reloads_total",
+			Help:      "The total number of times a changed oauth token was loaded",
+		}, []string{"account"}),
+		tokenLastLoad: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: c.Namespace,
+			Name:      "token_file_last_load_timestamp_seconds",
+			Help:      "The time the oauth token was last loaded successfully",
+		}, []string{"account"}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: c.Namespace + "_exporter",
+			Name:      "config_last_reload_successful",
+			Help:      "Whether the last configuration reload attempt was successful",
+		}),
+		lastTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: c.Namespace + "_exporter",
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "The time of the last successful configuration reload",
+		}),
+	}
+}
+
+// collectors returns the metrics of the reloader to be registered
+func (rl *reloader) collectors() []prometheus.Collector {
+	return []prometheus.Collector{rl.tokenReloads, rl.tokenLastLoad, rl.lastSuccess, rl.lastTimestamp}
+}
+
+// start creates the clients of the accounts of the initial config
+func (rl *reloader) start() ([]exporter.AccountGatherer, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	accounts, err := rl.applyAccounts(rl.config)
+	if err != nil {
+		return nil, err
+	}
+	rl.lastSuccess.Set(1)
+	rl.lastTimestamp.SetToCurrentTime()
+	return accounts, nil
+}
+
+// reload loads the config again and applies it. An invalid config is rejected as a whole
+// and the current one stays in effect.
+func (rl *reloader) reload() error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	err := rl.apply()
+	if err != nil {
+		log.Errorf("Failed to reload the config: %v", err)
+		rl.lastSuccess.Set(0)
+		return err
+	}
+	log.Info("Reloaded the config")
+	rl.lastSuccess.Set(1)
+	rl.lastTimestamp.SetToCurrentTime()
+	return nil
+}
+
+// handleReload reloads the config on a POST request to /-/reload
+func (rl *reloader) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := rl.reload(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to reload the config: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("OK"))
+}
+
+// shutdownTimeout returns the shutdown timeout of the current config
+func (rl *reloader) shutdownTimeout() time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return time.Duration(rl.config.ShutdownTimeoutSeconds) * time.Second
+}
+
+// stop stops the token watchers of the accounts
+func (rl *reloader) stop() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	for _, runner := range rl.accounts {
+		if runner.stopWatch != nil {
+			close(runner.stopWatch)
+			runner.stopWatch = nil
+		}
+	}
+}
+
+func (rl *reloader) apply() error {
+	c, err := config.Load(rl.reader, rl.flags)
+	if err != nil {
+		return err
+	}
+	if err := requiresRestart(rl.config, c); err != nil {
+		return err
+	}
+	if err := log.ValidateLevel(c.LogLevel); err != nil {
+		return err
+	}
+
+	accounts, err := rl.applyAccounts(c)
+	if err != nil {
+		return err
+	}
+
+	// nothing can fail from here on, so a rejected config changes nothing
+	log.SetLevel(c.LogLevel)
+	if rl.exporter != nil {
+		rl.exporter.SetAccounts(accounts)
+		rl.exporter.ApplyConfig(c)
+	}
+	if rl.probe != nil {
+		rl.probe.SetConfig(c)
+	}
+	rl.config = c
+	return nil
+}
+
+// applyAccounts keeps the clients of the accounts whose API base URL didn't change,
+// creates the clients of the new accounts and stops the removed ones.
+func (rl *reloader) applyAccounts(c *config.Config) ([]exporter.AccountGatherer, error) {
+	runners := map[string]*accountRunner{}
+	for _, a := range c.Accounts {
+		if old, ok := rl.accounts[a.Name]; ok && old.account.APIBaseURL == a.APIBaseURL {
+			runners[a.Name] = &accountRunner{account: a, authClient: old.authClient, client: old.client}
+			continue
+		}
+		authClient := rl.newAuthClient(a.Name, a.OAuthToken)
+		rc, err := exporter.NewAccountRemoClient(c, a, authClient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create remo client for account %s: %w", a.Name, err)
+		}
+		runners[a.Name] = &accountRunner{account: a, authClient: authClient, client: rc}
+	}
+
+	// nothing can fail from here on
+	for name, old := range rl.accounts {
+		if old.stopWatch != nil {
+			close(old.stopWatch)
+		}
+		if _, ok := runners[name]; !ok {
+			log.Infof("Removed account %s", name)
+			rl.tokenReloads.DeleteLabelValues(name)
+			rl.tokenLastLoad.DeleteLabelValues(name)
+		}
+	}
+
+	accounts := []exporter.AccountGatherer{}
+	for _, a := range c.Accounts {
+		runner := runners[a.Name]
+		if old, ok := rl.accounts[a.Name]; ok && old.client == runner.client {
+			runner.client.ApplyConfig(c)
+			if old.account.OAuthToken != a.OAuthToken {
+				runner.authClient.SetToken(a.OAuthToken)
+				runner.client.ResetAuthBackoff()
+			}
+		}
+		runner.authClient.OnUnauthorized(nil)
+		if a.TokenProvider != nil {
+			runner.stopWatch = make(chan struct{})
+			rl.watchToken(c, runner)
+		}
+		accounts = append(accounts, exporter.AccountGatherer{Name: a.Name, Client: runner.client})
+	}
+	rl.accounts = runners
+	return accounts, nil
+}
+
+// requiresRestart returns an error if a setting changed which can't be applied while running
+func requiresRestart(old *config.Config, c *config.Config) error {
+	changed := []struct {
+		name    string
+		changed bool
+	}{
+		{"PORT", old.ListenPort != c.ListenPort},
+		{"METRICS_PATH", old.MetricsPath != c.MetricsPath},
+		{"PROBE_CREDENTIALS", old.ProbeCredentials != c.ProbeCredentials},
+		{"WEB_CONFIG_FILE", old.WebConfigFile != c.WebConfigFile},
+		{"WEB_LISTEN_ADDRESS", strings.Join(old.ListenAddresses, ",") != strings.Join(c.ListenAddresses, ",")},
+		{"HTTP_RETRIES", old.HTTPRetries != c.HTTPRetries},
+		{"HTTP_PROXY_URL", old.HTTPProxyURL != c.HTTPProxyURL},
+		{"HTTP_CA_FILE", old.HTTPCAFile != c.HTTPCAFile},
+		{"HTTP_CLIENT_CERT_FILE", old.HTTPClientCertFile != c.HTTPClientCertFile},
+		{"HTTP_CLIENT_KEY_FILE", old.HTTPClientKeyFile != c.HTTPClientKeyFile},
+		{"HTTP_TLS_MIN_VERSION", old.HTTPTLSMinVersion != c.HTTPTLSMinVersion},
+		{"HTTP_INSECURE_SKIP_VERIFY", old.HTTPInsecureSkipVerify != c.HTTPInsecureSkipVerify},
+		{"LABEL_MODE", old.LabelMode != c.LabelMode},
+		{"NAMESPACE", old.Namespace != c.Namespace},
+		{"METRIC_NAMES", old.MetricNames != c.MetricNames},
+		{"the keys of labels", strings.Join(old.Labels.LabelKeys(), ",") != strings.Join(c.Labels.LabelKeys(), ",")},
+	}
+	for _, s := range changed {
+		if s.changed {
+			return fmt.Errorf("changing %s requires a restart", s.name)
+		}
+	}
+	return nil
+}
+
+// watchToken swaps the token of the account whenever the token fetched by its secret provider changes.
+// A token file is reloaded when it changes and periodically, other providers are asked again shortly
+// before the token expires. The token is also fetched again when the remo API responds with 401.
+// A new token is used right away, even while the client is backing off because of an invalid token.
+func (rl *reloader) watchToken(c *config.Config, runner *accountRunner) {
+	a := runner.account
+	tr := config.NewTokenRefresher(a.TokenProvider, &config.Secret{Token: a.OAuthToken, ExpiresAt: a.TokenExpiresAt})
+	tr.OnLoad = func(token string, changed bool, loadedAt time.Time) {
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+		// the refresher of a replaced config must not put back the token it loaded
+		if rl.accounts[a.Name] != runner {
+			return
+		}
+		rl.tokenLastLoad.WithLabelValues(a.Name).Set(float64(loadedAt.Unix()))
+		if changed {
+			log.Infof("Loaded a new oauth token for account %s", a.Name)
+			runner.authClient.SetToken(token)
+			// try the new token right away even if the old one was rejected
+			runner.client.ResetAuthBackoff()
+			rl.tokenReloads.WithLabelValues(a.Name).Inc()
+		}
+	}
+	runner.refresher = tr
+	rl.tokenLastLoad.WithLabelValues(a.Name).Set(float64(time.Now().Unix()))
+	rl.tokenReloads.WithLabelValues(a.Name)
+
+	runner.authClient.OnUnauthorized(func() bool {
+		changed, err := tr.Reload()
+		if err != nil {
+			log.Errorf("Reloading the oauth token of account %s failed: %v", a.Name, err)
+		}
+		return changed
+	})
+
+	if a.OAuthTokenFile == "" {
+		go tr.Watch(tokenExpiryCheckInterval, false, runner.stopWatch)
+		return
+	}
+	if err := tr.WatchFile(a.OAuthTokenFile, runner.stopWatch); err != nil {
+		log.Errorf("Failed to watch the oauth token file of account %s: %v", a.Name, err)
+	}
+	// the file is checked periodically as well, e.g. on filesystems without change notifications
+	if c.TokenFileReloadSeconds > 0 {
+		go tr.Watch(time.Duration(c.TokenFileReloadSeconds)*time.Second, true, runner.stopWatch)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/kenfdev/remo-exporter/config"
+	authHttp "github.com/kenfdev/remo-exporter/http"
+)
+
+// newTestReloader returns a started reloader of the configuration file at path
+func newTestReloader(t *testing.T, path string, content string) *reloader {
+	t.Helper()
+	writeConfig(t, path, content)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse([]string{"--config.file", path}); err != nil {
+		t.Fatal(err)
+	}
+	r := config.NewFileReader()
+	c, err := config.Load(r, flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := newReloader(r, flags, c, func(account string, token string) *authHttp.AuthHttpClient {
+		return authHttp.NewAuthHttpClient(token)
+	})
+	if _, err := rl.start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(rl.stop)
+	return rl
+}
+
+func writeConfig(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadAppliesValidConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	rl := newTestReloader(t, path, "oauth_token: some_token\ncache_invalidation_seconds: 60\n")
+	rl.lastTimestamp.Set(0)
+
+	writeConfig(t, path, "oauth_token: some_token\ncache_invalidation_seconds: 30\n")
+	if err := rl.reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want, got := 30, rl.config.CacheInvalidationSeconds; want != got {
+		t.Fatalf("unexpected cache invalidation seconds want=%d got=%d", want, got)
+	}
+	if want, got := 1.0, testutil.ToFloat64(rl.lastSuccess); want != got {
+		t.Fatalf("unexpected last reload success want=%v got=%v", want, got)
+	}
+	if got := testutil.ToFloat64(rl.lastTimestamp); got == 0 {
+		t.Fatal("expected the timestamp of the last successful reload to be set")
+	}
+}
+
+func TestReloadRejectsConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"invalid value", "oauth_token: some_token\ncache_invalidation_seconds: -1\n", "must not be negative"},
+		{"invalid log level", "oauth_token: some_token\nlog_level: loud\n", "not a valid logrus Level"},
+		{"changed port", "oauth_token: some_token\nport: 9999\n", "changing PORT requires a restart"},
+		{"changed namespace", "oauth_token: some_token\nnamespace: nature\n", "changing NAMESPACE requires a restart"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yml")
+			rl := newTestReloader(t, path, "oauth_token: some_token\n")
+			old := rl.config
+			rl.lastTimestamp.Set(42)
+
+			writeConfig(t, path, tt.config)
+			err := rl.reload()
+
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("unexpected error want=%q got=%v", tt.err, err)
+			}
+			if rl.config != old {
+				t.Fatal("expected the current config to stay in effect")
+			}
+			if want, got := 0.0, testutil.ToFloat64(rl.lastSuccess); want != got {
+				t.Fatalf("unexpected last reload success want=%v got=%v", want, got)
+			}
+			if want, got := 42.0, testutil.ToFloat64(rl.lastTimestamp); want != got {
+				t.Fatalf("unexpected timestamp of the last successful reload want=%v got=%v", want, got)
+			}
+		})
+	}
+}
+
+func TestReloadDeletesTheTokenSeriesOfRemovedAccounts(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"home", "office"} {
+		writeConfig(t, filepath.Join(dir, name), name+"_token")
+	}
+	path := filepath.Join(dir, "config.yml")
+	account := func(name string) string {
+		return "  - name: " + name + "\n    oauth_token_file: " + filepath.Join(dir, name) + "\n"
+	}
+	rl := newTestReloader(t, path, "accounts:\n"+account("home")+account("office"))
+	if want, got := 2, testutil.CollectAndCount(rl.tokenReloads); want != got {
+		t.Fatalf("unexpected number of token reload series want=%d got=%d", want, got)
+	}
+
+	writeConfig(t, path, "accounts:\n"+account("home"))
+	if err := rl.reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want, got := 1, testutil.CollectAndCount(rl.tokenReloads); want != got {
+		t.Fatalf("unexpected number of token reload series want=%d got=%d", want, got)
+	}
+	if want, got := 1, testutil.CollectAndCount(rl.tokenLastLoad); want != got {
+		t.Fatalf("unexpected number of token load timestamp series want=%d got=%d", want, got)
+	}
+}
+
+func TestReloadIgnoresTheTokensOfReplacedRefreshers(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig(t, filepath.Join(dir, "old"), "old_token")
+	writeConfig(t, filepath.Join(dir, "new"), "new_token")
+	path := filepath.Join(dir, "config.yml")
+	account := func(file string) string {
+		return "accounts:\n  - name: home\n    oauth_token_file: " + filepath.Join(dir, file) + "\n"
+	}
+	rl := newTestReloader(t, path, account("old"))
+	replaced := rl.accounts["home"].refresher
+
+	writeConfig(t, path, account("new"))
+	if err := rl.reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// a reload of the replaced refresher which was in flight during the config reload
+	writeConfig(t, filepath.Join(dir, "old"), "stale_token")
+	if _, err := replaced.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+	resp, err := rl.accounts["home"].authClient.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if want, got := "Bearer new_token", authorization; want != got {
+		t.Fatalf("unexpected authorization header want=%q got=%q", want, got)
+	}
+	if want, got := 0.0, testutil.ToFloat64(rl.tokenReloads.WithLabelValues("home")); want != got {
+		t.Fatalf("unexpected number of token reloads want=%v got=%v", want, got)
+	}
+}
+
+func TestReloadEndpointAcceptsOnlyPOST(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	rl := newTestReloader(t, path, "oauth_token: some_token\n")
+
+	for _, method := range []string{http.MethodGet, http.MethodPut} {
+		w := httptest.NewRecorder()
+		rl.handleReload(w, httptest.NewRequest(method, "/-/reload", nil))
+		if want, got := http.StatusMethodNotAllowed, w.Code; want != got {
+			t.Fatalf("unexpected status code of %s want=%d got=%d", method, want, got)
+		}
+		if want, got := http.MethodPost, w.Header().Get("Allow"); want != got {
+			t.Fatalf("unexpected Allow header want=%s got=%s", want, got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	rl.handleReload(w, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+	if want, got := http.StatusOK, w.Code; want != got {
+		t.Fatalf("unexpected status code of POST want=%d got=%d", want, got)
+	}
+
+	writeConfig(t, path, "oauth_token: some_token\nport: 9999\n")
+	w = httptest.NewRecorder()
+	rl.handleReload(w, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+	if want, got := http.StatusInternalServerError, w.Code; want != got {
+		t.Fatalf("unexpected status code of a rejected config want=%d got=%d", want, got)
+	}
+}
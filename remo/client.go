@@ -0,0 +1,291 @@
+// Package remo is a client for the Nature Remo cloud API.
+//
+//	client := remo.NewClient(token)
+//	devices, resp, err := client.GetDevices(ctx)
+//
+// Every call returns a Response holding the rate limit of the API. Errors responded by the API
+// are returned as *types.APIError.
+package remo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kenfdev/remo-exporter/types"
+)
+
+// DefaultBaseURL is the base URL of the Nature Remo cloud API
+const DefaultBaseURL = "https://api.nature.global"
+
+// maxErrorMessageLength truncates error bodies which couldn't be decoded
+const maxErrorMessageLength = 256
+
+// Doer sends HTTP requests. *http.Client implements it.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client requests the Nature Remo cloud API. It is safe for concurrent use.
+type Client struct {
+	doer    Doer
+	baseURL string
+	token   string
+}
+
+// ClientOption configures a Client
+type ClientOption func(*Client)
+
+// WithBaseURL sets the base URL of the API, e.g. the URL of a remotest.Server
+func WithBaseURL(baseURL string) ClientOption {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithHTTPClient sets the client sending the requests. It defaults to http.DefaultClient.
+func WithHTTPClient(doer Doer) ClientOption {
+	return func(c *Client) {
+		c.doer = doer
+	}
+}
+
+// NewClient returns a client authenticating with the oauth token.
+// No Authorization header is set if token is empty, e.g. because the Doer of WithHTTPClient sets it.
+func NewClient(token string, opts ...ClientOption) *Client {
+	c := &Client{
+		doer:    http.DefaultClient,
+		baseURL: DefaultBaseURL,
+		token:   token,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Response describes a response of the API
+type Response struct {
+	StatusCode int
+	// Meta is the rate limit of the API when the response was sent
+	Meta *types.Meta
+	// Duration is the time it took to send the request and read the response
+	Duration time.Duration
+	// Body is the raw body of the response
+	Body []byte
+}
+
+// GetUser gets the user of the oauth token
+func (c *Client) GetUser(ctx context.Context) (*types.User, *Response, error) {
+	user := &types.User{}
+	resp, err := c.do(ctx, http.MethodGet, "/1/users/me", nil, user)
+	if err != nil {
+		return nil, resp, err
+	}
+	return user, resp, nil
+}
+
+// UpdateUser changes the nickname of the user of the oauth token
+func (c *Client) UpdateUser(ctx context.Context, nickname string) (*types.User, *Response, error) {
+	user := &types.User{}
+	resp, err := c.do(ctx, http.MethodPost, "/1/users/me", url.Values{"nickname": {nickname}}, user)
+	if err != nil {
+		return nil, resp, err
+	}
+	return user, resp, nil
+}
+
+// GetDevices gets the remo devices of the user
+func (c *Client) GetDevices(ctx context.Context) ([]*types.Device, *Response, error) {
+	devices := []*types.Device{}
+	resp, err := c.do(ctx, http.MethodGet, "/1/devices", nil, &devices)
+	if err != nil {
+		return nil, resp, err
+	}
+	return devices, resp, nil
+}
+
+// GetAppliances gets the appliances of the user
+func (c *Client) GetAppliances(ctx context.Context) ([]*types.Appliance, *Response, error) {
+	var appliances []*types.Appliance
+	resp, err := c.do(ctx, http.MethodGet, "/1/appliances", nil, &appliances)
+	if err != nil {
+		return nil, resp, err
+	}
+	return appliances, resp, nil
+}
+
+// GetSignals gets the signals learned for an appliance
+func (c *Client) GetSignals(ctx context.Context, applianceID string) ([]*types.Signal, *Response, error) {
+	signals := []*types.Signal{}
+	resp, err := c.do(ctx, http.MethodGet, "/1/appliances/"+url.PathEscape(applianceID)+"/signals", nil, &signals)
+	if err != nil {
+		return nil, resp, err
+	}
+	return signals, resp, nil
+}
+
+// SendSignal sends a signal learned for an appliance
+func (c *Client) SendSignal(ctx context.Context, signalID string) (*Response, error) {
+	return c.do(ctx, http.MethodPost, "/1/signals/"+url.PathEscape(signalID)+"/send", url.Values{}, nil)
+}
+
+// AirconSettingsRequest holds the settings to send to an air conditioner.
+// Empty settings are left unchanged.
+type AirconSettingsRequest struct {
+	Temperature     string
+	TemperatureUnit string
+	OperationMode   string
+	AirVolume       string
+	AirDirection    string
+	AirDirectionH   string
+	// Button is "power-off" to turn the air conditioner off or empty to turn it on
+	Button string
+}
+
+func (r *AirconSettingsRequest) values() url.Values {
+	values := url.Values{}
+	set := func(key string, value string) {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	set("temperature", r.Temperature)
+	set("temperature_unit", r.TemperatureUnit)
+	set("operation_mode", r.OperationMode)
+	set("air_volume", r.AirVolume)
+	set("air_direction", r.AirDirection)
+	set("air_direction_h", r.AirDirectionH)
+	// an empty button turns the air conditioner on
+	values.Set("button", r.Button)
+	return values
+}
+
+// UpdateAirconSettings sends settings to an air conditioner
+func (c *Client) UpdateAirconSettings(ctx context.Context, applianceID string, settings *AirconSettingsRequest) (*types.AirconSettings, *Response, error) {
+	result := &types.AirconSettings{}
+	resp, err := c.do(ctx, http.MethodPost, "/1/appliances/"+url.PathEscape(applianceID)+"/aircon_settings", settings.values(), result)
+	if err != nil {
+		return nil, resp, err
+	}
+	return result, resp, nil
+}
+
+// SendLightButton presses a button of the remote controller of a light, e.g. "on" or "off"
+func (c *Client) SendLightButton(ctx context.Context, applianceID string, button string) (*types.LightState, *Response, error) {
+	state := &types.LightState{}
+	resp, err := c.do(ctx, http.MethodPost, "/1/appliances/"+url.PathEscape(applianceID)+"/light", url.Values{"button": {button}}, state)
+	if err != nil {
+		return nil, resp, err
+	}
+	return state, resp, nil
+}
+
+// SendTVButton presses a button of the remote controller of a TV, e.g. "power"
+func (c *Client) SendTVButton(ctx context.Context, applianceID string, button string) (*types.TVState, *Response, error) {
+	state := &types.TVState{}
+	resp, err := c.do(ctx, http.MethodPost, "/1/appliances/"+url.PathEscape(applianceID)+"/tv", url.Values{"button": {button}}, state)
+	if err != nil {
+		return nil, resp, err
+	}
+	return state, resp, nil
+}
+
+// do sends a request with form as the url encoded body and decodes the response into v.
+// A GET request is sent if form is nil.
+// The Response is returned whenever the API responded, also together with an error.
+func (c *Client) do(ctx context.Context, method string, path string, form url.Values, v interface{}) (*Response, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	start := time.Now()
+	httpResp, err := c.doer.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp := &Response{
+		StatusCode: httpResp.StatusCode,
+		Meta:       ParseMeta(httpResp.Header),
+		Duration:   time.Since(start),
+		Body:       bodyBytes,
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return resp, newAPIError(resp)
+	}
+
+	if v != nil {
+		if err := json.Unmarshal(bodyBytes, v); err != nil {
+			return resp, fmt.Errorf("failed to decode %s: %w", strings.TrimPrefix(path, "/1/"), err)
+		}
+	}
+	return resp, nil
+}
+
+// ParseMeta reads the rate limit from the headers of a response of the API.
+// Missing or malformed headers are read as 0.
+func ParseMeta(header http.Header) *types.Meta {
+	parse := func(key string) float64 {
+		v, err := strconv.ParseFloat(header.Get(key), 64)
+		if err != nil {
+			return 0
+		}
+		return v
+	}
+
+	return &types.Meta{
+		RateLimitLimit:     parse("X-Rate-Limit-Limit"),
+		RateLimitRemaining: parse("X-Rate-Limit-Remaining"),
+		RateLimitReset:     parse("X-Rate-Limit-Reset"),
+	}
+}
+
+// newAPIError decodes the error body returned by the API.
+// Bodies which aren't JSON (e.g. from a proxy) are kept as the message.
+func newAPIError(resp *Response) *types.APIError {
+	apiErr := &types.APIError{
+		StatusCode: resp.StatusCode,
+		Meta:       resp.Meta,
+	}
+	if err := json.Unmarshal(resp.Body, apiErr); err != nil {
+		apiErr.Message = truncate(strings.TrimSpace(string(resp.Body)), maxErrorMessageLength)
+	}
+	return apiErr
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 encoded character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,226 @@
+package remo_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kenfdev/remo-exporter/remo"
+	"github.com/kenfdev/remo-exporter/remo/remotest"
+	"github.com/kenfdev/remo-exporter/types"
+)
+
+func newTestClient(t *testing.T) (*remo.Client, *remotest.Server) {
+	server := remotest.NewServer("dummy_token")
+	t.Cleanup(server.Close)
+	return remo.NewClient("dummy_token", remo.WithBaseURL(server.URL)), server
+}
+
+func TestGetDevices(t *testing.T) {
+	client, server := newTestClient(t)
+	server.SetDevices([]*types.Device{
+		{ID: "living_id", Name: "Living", NewestEvents: types.Event{types.SensorTemperature: &types.SensorValue{Value: 20.5}}},
+	})
+
+	devices, resp, err := client.GetDevices(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 1, len(devices); want != got {
+		t.Fatalf("unexpected number of devices want=%d got=%d", want, got)
+	}
+	if want, got := 20.5, devices[0].NewestEvents[types.SensorTemperature].Value; want != got {
+		t.Errorf("unexpected temperature want=%v got=%v", want, got)
+	}
+	if want, got := float64(remotest.DefaultRateLimit), resp.Meta.RateLimitLimit; want != got {
+		t.Errorf("unexpected rate limit want=%v got=%v", want, got)
+	}
+	if want, got := float64(remotest.DefaultRateLimit-1), resp.Meta.RateLimitRemaining; want != got {
+		t.Errorf("unexpected remaining rate limit want=%v got=%v", want, got)
+	}
+	if resp.Meta.RateLimitReset == 0 {
+		t.Error("expected the rate limit reset to be set")
+	}
+}
+
+func TestUser(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	user, _, err := client.UpdateUser(context.Background(), "John")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "John", user.Nickname; want != got {
+		t.Errorf("unexpected nickname want=%s got=%s", want, got)
+	}
+
+	user, _, err = client.GetUser(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "John", user.Nickname; want != got {
+		t.Errorf("unexpected nickname want=%s got=%s", want, got)
+	}
+}
+
+func TestSignals(t *testing.T) {
+	client, server := newTestClient(t)
+	server.SetAppliances([]*types.Appliance{
+		{ID: "fan_id", Nickname: "Fan", Type: "IR", Signals: []*types.Signal{{ID: "fan_on", Name: "on"}, {ID: "fan_off", Name: "off"}}},
+	})
+
+	signals, _, err := client.GetSignals(context.Background(), "fan_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := []*types.Signal{{ID: "fan_on", Name: "on"}, {ID: "fan_off", Name: "off"}}, signals; !reflect.DeepEqual(want, got) {
+		t.Errorf("unexpected signals want=%v got=%v", want, got)
+	}
+
+	if _, err := client.SendSignal(context.Background(), "fan_off"); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := []string{"fan_off"}, server.SentSignals(); !reflect.DeepEqual(want, got) {
+		t.Errorf("unexpected sent signals want=%v got=%v", want, got)
+	}
+}
+
+func TestAppliances(t *testing.T) {
+	client, server := newTestClient(t)
+	server.SetAppliances([]*types.Appliance{
+		{ID: "aircon_id", Type: "AC", Settings: &types.AirconSettings{Temp: "25", Mode: "cool"}},
+		{ID: "light_id", Type: "LIGHT"},
+		{ID: "tv_id", Type: "TV"},
+	})
+	ctx := context.Background()
+
+	settings, _, err := client.UpdateAirconSettings(ctx, "aircon_id", &remo.AirconSettingsRequest{Temperature: "27"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Temp != "27" || settings.Mode != "cool" || settings.Button != "" {
+		t.Errorf("unexpected aircon settings %+v", settings)
+	}
+
+	light, _, err := client.SendLightButton(ctx, "light_id", "on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := (&types.LightState{Power: "on", LastButton: "on"}), light; !reflect.DeepEqual(want, got) {
+		t.Errorf("unexpected light state want=%+v got=%+v", want, got)
+	}
+
+	if _, _, err := client.SendTVButton(ctx, "tv_id", "power"); err != nil {
+		t.Fatal(err)
+	}
+
+	appliances, _, err := client.GetAppliances(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "27", appliances[0].Settings.Temp; want != got {
+		t.Errorf("unexpected temperature of the appliance want=%s got=%s", want, got)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	client, server := newTestClient(t)
+	server.FailNext(http.StatusServiceUnavailable, 503001, "Service Unavailable")
+
+	_, resp, err := client.GetDevices(context.Background())
+
+	var apiErr *types.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an APIError got=%v", err)
+	}
+	if apiErr.StatusCode != http.StatusServiceUnavailable || apiErr.Code != 503001 || apiErr.Message != "Service Unavailable" {
+		t.Errorf("unexpected error %v", apiErr)
+	}
+	if apiErr.Meta == nil || apiErr.Meta.RateLimitLimit == 0 {
+		t.Errorf("expected the rate limit in the error got=%+v", apiErr.Meta)
+	}
+	if want, got := http.StatusServiceUnavailable, resp.StatusCode; want != got {
+		t.Errorf("unexpected status code want=%d got=%d", want, got)
+	}
+}
+
+func TestAPIErrorBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		code    int
+		message string
+	}{
+		{"fields of the client", `{"code": 503001, "message": "Service Unavailable", "statuscode": 200, "meta": {"RateLimitLimit": 1}}`, 503001, "Service Unavailable"},
+		{"not json", "<html>Bad Gateway</html>\n", 0, "<html>Bad Gateway</html>"},
+		{"truncated within a character", strings.Repeat("a", 255) + "温度", 0, strings.Repeat("a", 255)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Rate-Limit-Limit", "30")
+				w.WriteHeader(http.StatusBadGateway)
+				w.Write([]byte(tt.body))
+			}))
+			t.Cleanup(server.Close)
+
+			_, _, err := remo.NewClient("dummy_token", remo.WithBaseURL(server.URL)).GetDevices(context.Background())
+
+			var apiErr *types.APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected an APIError got=%v", err)
+			}
+			if want, got := http.StatusBadGateway, apiErr.StatusCode; want != got {
+				t.Errorf("unexpected status code want=%d got=%d", want, got)
+			}
+			if want, got := 30.0, apiErr.Meta.RateLimitLimit; want != got {
+				t.Errorf("unexpected rate limit want=%v got=%v", want, got)
+			}
+			if want, got := tt.code, apiErr.Code; want != got {
+				t.Errorf("unexpected code want=%d got=%d", want, got)
+			}
+			if want, got := tt.message, apiErr.Message; want != got {
+				t.Errorf("unexpected message want=%q got=%q", want, got)
+			}
+		})
+	}
+}
+
+func TestUnauthorizedAndRateLimited(t *testing.T) {
+	server := remotest.NewServer("dummy_token")
+	t.Cleanup(server.Close)
+	server.SetRateLimit(1)
+
+	var apiErr *types.APIError
+	_, _, err := remo.NewClient("wrong_token", remo.WithBaseURL(server.URL)).GetDevices(context.Background())
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 got=%v", err)
+	}
+
+	_, _, err = remo.NewClient("dummy_token", remo.WithBaseURL(server.URL)).GetDevices(context.Background())
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected 429 got=%v", err)
+	}
+}
+
+func TestContextCanceled(t *testing.T) {
+	client, server := newTestClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, resp, err := client.GetDevices(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the context error got=%v", err)
+	}
+	if resp != nil {
+		t.Errorf("expected no response got=%+v", resp)
+	}
+	if want, got := 0, server.Requests(); want != got {
+		t.Errorf("unexpected number of requests want=%d got=%d", want, got)
+	}
+}
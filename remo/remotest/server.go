@@ -0,0 +1,325 @@
+// Package remotest provides a fake Nature Remo cloud API for tests.
+//
+//	server := remotest.NewServer("token")
+//	defer server.Close()
+//	server.SetDevices(devices)
+//	client := remo.NewClient("token", remo.WithBaseURL(server.URL))
+package remotest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kenfdev/remo-exporter/types"
+)
+
+const (
+	// DefaultRateLimit is the number of requests allowed in a rate limit period like the real API
+	DefaultRateLimit = 30
+	// RateLimitPeriod is the period after which the rate limit is reset
+	RateLimitPeriod = 5 * time.Minute
+)
+
+// Server is a fake Nature Remo cloud API holding the user, devices and appliances set by the test.
+// Requests must be authenticated with the token of the server. It is safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	token      string
+	user       *types.User
+	devices    []*types.Device
+	appliances []*types.Appliance
+	sent       []string
+	requests   int
+	limit      int
+	remaining  int
+	reset      time.Time
+	failures   []*failure
+}
+
+// failure is an error the server responds with instead of serving a request
+type failure struct {
+	statusCode int
+	code       int
+	message    string
+}
+
+// NewServer starts a server accepting the oauth token
+func NewServer(token string) *Server {
+	s := &Server{
+		token: token,
+		user:  &types.User{ID: "user_id", Nickname: "remotest"},
+		limit: DefaultRateLimit,
+	}
+	s.remaining = s.limit
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/1/users/me", s.handleUser)
+	mux.HandleFunc("/1/devices", s.handleDevices)
+	mux.HandleFunc("/1/appliances", s.handleAppliances)
+	mux.HandleFunc("/1/appliances/", s.handleAppliance)
+	mux.HandleFunc("/1/signals/", s.handleSignal)
+	s.Server = httptest.NewServer(s.middleware(mux))
+	return s
+}
+
+// SetUser sets the user returned by users/me
+func (s *Server) SetUser(user *types.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.user = user
+}
+
+// SetDevices sets the devices returned by devices
+func (s *Server) SetDevices(devices []*types.Device) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.devices = devices
+}
+
+// SetAppliances sets the appliances returned by appliances. Their signals, settings and states
+// are changed by the requests sent to the appliances.
+func (s *Server) SetAppliances(appliances []*types.Appliance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.appliances = appliances
+}
+
+// SetRateLimit sets the number of requests allowed in the current rate limit period.
+// The server responds with 429 when no request is remaining.
+func (s *Server) SetRateLimit(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limit = limit
+	s.remaining = limit
+}
+
+// FailNext lets the next request fail with the status code and the error body of the API
+func (s *Server) FailNext(statusCode int, code int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, &failure{statusCode: statusCode, code: code, message: message})
+}
+
+// SentSignals returns the IDs of the signals sent in order
+func (s *Server) SentSignals() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.sent...)
+}
+
+// Requests returns the number of requests the server received
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests
+}
+
+// middleware counts the requests, sets the rate limit headers and checks the token
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests++
+		now := time.Now()
+		if !now.Before(s.reset) {
+			s.remaining = s.limit
+			s.reset = now.Add(RateLimitPeriod)
+		}
+		limited := s.remaining == 0
+		if !limited {
+			s.remaining--
+		}
+		w.Header().Set("X-Rate-Limit-Limit", strconv.Itoa(s.limit))
+		w.Header().Set("X-Rate-Limit-Remaining", strconv.Itoa(s.remaining))
+		w.Header().Set("X-Rate-Limit-Reset", strconv.FormatInt(s.reset.Unix(), 10))
+		var fail *failure
+		if len(s.failures) > 0 {
+			fail, s.failures = s.failures[0], s.failures[1:]
+		}
+		s.mu.Unlock()
+
+		switch {
+		case fail != nil:
+			writeError(w, fail.statusCode, fail.code, fail.message)
+		case r.Header.Get("Authorization") != "Bearer "+s.token:
+			writeError(w, http.StatusUnauthorized, 401001, "Unauthorized")
+		case limited:
+			writeError(w, http.StatusTooManyRequests, 429001, "Too Many Requests")
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (s *Server) handleUser(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		s.user.Nickname = r.PostFormValue("nickname")
+	default:
+		writeError(w, http.StatusMethodNotAllowed, 0, "Method Not Allowed")
+		return
+	}
+	writeJSON(w, s.user)
+}
+
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, 0, "Method Not Allowed")
+		return
+	}
+	devices := s.devices
+	if devices == nil {
+		devices = []*types.Device{}
+	}
+	writeJSON(w, devices)
+}
+
+func (s *Server) handleAppliances(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, 0, "Method Not Allowed")
+		return
+	}
+	appliances := s.appliances
+	if appliances == nil {
+		appliances = []*types.Appliance{}
+	}
+	writeJSON(w, appliances)
+}
+
+// handleAppliance serves /1/appliances/{id}/{signals,aircon_settings,light,tv}
+func (s *Server) handleAppliance(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/1/appliances/"), "/")
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, 404001, "Not Found")
+		return
+	}
+	app := s.appliance(parts[0])
+	if app == nil {
+		writeError(w, http.StatusNotFound, 404001, "Not Found")
+		return
+	}
+
+	switch {
+	case parts[1] == "signals" && r.Method == http.MethodGet:
+		signals := app.Signals
+		if signals == nil {
+			signals = []*types.Signal{}
+		}
+		writeJSON(w, signals)
+	case parts[1] == "aircon_settings" && r.Method == http.MethodPost:
+		if app.Settings == nil {
+			app.Settings = &types.AirconSettings{}
+		}
+		update := func(field *string, key string) {
+			if v := r.PostFormValue(key); v != "" {
+				*field = v
+			}
+		}
+		update(&app.Settings.Temp, "temperature")
+		update(&app.Settings.TempUnit, "temperature_unit")
+		update(&app.Settings.Mode, "operation_mode")
+		update(&app.Settings.Vol, "air_volume")
+		update(&app.Settings.Dir, "air_direction")
+		update(&app.Settings.DirH, "air_direction_h")
+		app.Settings.Button = r.PostFormValue("button")
+		app.Settings.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+		writeJSON(w, app.Settings)
+	case parts[1] == "light" && r.Method == http.MethodPost:
+		if app.Light == nil {
+			app.Light = &types.Light{}
+		}
+		if app.Light.State == nil {
+			app.Light.State = &types.LightState{}
+		}
+		button := r.PostFormValue("button")
+		app.Light.State.LastButton = button
+		if button == "on" || button == "off" {
+			app.Light.State.Power = button
+		}
+		writeJSON(w, app.Light.State)
+	case parts[1] == "tv" && r.Method == http.MethodPost:
+		if app.TV == nil {
+			app.TV = &types.TV{}
+		}
+		if app.TV.State == nil {
+			app.TV.State = &types.TVState{Input: "t"}
+		}
+		writeJSON(w, app.TV.State)
+	default:
+		writeError(w, http.StatusNotFound, 404001, "Not Found")
+	}
+}
+
+// handleSignal serves /1/signals/{id}/send
+func (s *Server) handleSignal(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/1/signals/"), "/send")
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/send") || !s.hasSignal(id) {
+		writeError(w, http.StatusNotFound, 404001, "Not Found")
+		return
+	}
+	s.sent = append(s.sent, id)
+	writeJSON(w, struct{}{})
+}
+
+func (s *Server) appliance(id string) *types.Appliance {
+	for _, app := range s.appliances {
+		if app.ID == id {
+			return app
+		}
+	}
+	return nil
+}
+
+func (s *Server) hasSignal(id string) bool {
+	for _, app := range s.appliances {
+		for _, signal := range app.Signals {
+			if signal.ID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}{code, message})
+}
@@ -18,14 +18,20 @@ echo "Build arguments: $OPT"
 export GOOS=linux
 export CGO_ENABLED=0
 
-# TODO: -ldflags
+VERSION_PKG=github.com/prometheus/common/version
+VERSION=${VERSION:-$(git describe --tags --always --dirty 2>/dev/null | sed 's/^v//')}
+LDFLAGS="-X ${VERSION_PKG}.Version=${VERSION} \
+	-X ${VERSION_PKG}.Revision=$(git rev-parse HEAD) \
+	-X ${VERSION_PKG}.Branch=$(git rev-parse --abbrev-ref HEAD) \
+	-X ${VERSION_PKG}.BuildUser=$(whoami)@$(hostname) \
+	-X ${VERSION_PKG}.BuildDate=$(date -u +%Y%m%d-%H:%M:%S)"
 
 export GOARCH=arm
 export GOARM=7
-CC=${CCARMV7} go build -o ./dist/remo-exporter-${GOOS}-${GOARCH}v7
+CC=${CCARMV7} go build -ldflags "${LDFLAGS}" -o ./dist/remo-exporter-${GOOS}-${GOARCH}v7
 
 export GOARCH=arm64
-CC=${CCARM64} go build -o ./dist/remo-exporter-${GOOS}-${GOARCH}
+CC=${CCARM64} go build -ldflags "${LDFLAGS}" -o ./dist/remo-exporter-${GOOS}-${GOARCH}
 
 export GOARCH=amd64
-go build -o ./dist/remo-exporter-${GOOS}-${GOARCH}
+go build -ldflags "${LDFLAGS}" -o ./dist/remo-exporter-${GOOS}-${GOARCH}
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -14,12 +15,17 @@ type SensorValue struct {
 	Value     float64   `json:"val"`
 	CreatedAt time.Time `json:"created_at"`
 }
-type Event struct {
-	Temperature  *SensorValue `json:"te"`
-	Humidity     *SensorValue `json:"hu"`
-	Illumination *SensorValue `json:"il"`
-	Motion       *SensorValue `json:"mo"`
-}
+
+// Keys of the sensors known to the exporter in the newest_events of a device
+const (
+	SensorTemperature  = "te"
+	SensorHumidity     = "hu"
+	SensorIllumination = "il"
+	SensorMotion       = "mo"
+)
+
+// Event holds the newest value of each sensor of a device keyed by the sensor key (e.g. te)
+type Event map[string]*SensorValue
 
 type Device struct {
 	Name              string  `json:"name"`
@@ -30,7 +36,7 @@ type Device struct {
 	TemperatureOffset int     `json:"temperature_offset"`
 	HumidityOffset    int     `json:"humidity_offset"`
 	Users             []*User `json:"users"`
-	NewestEvents      *Event  `json:"newest_events"`
+	NewestEvents      Event   `json:"newest_events"`
 }
 
 type Meta struct {
@@ -39,29 +45,44 @@ type Meta struct {
 	RateLimitRemaining float64
 }
 
-// GetDevicesResult is the result of invoking the Remo API
+// GetDevicesResult is the result of invoking the Remo API.
+// FetchedAt is the time the data was fetched from the API, even if it is served from the cache.
+// Duration and ResponseSize describe the request which fetched the data.
 type GetDevicesResult struct {
-	StatusCode int
-	Meta       *Meta
-	Devices    []*Device
-	IsCache    bool
+	StatusCode    int
+	Meta          *Meta
+	Devices       []*Device
+	IsCache       bool
+	FetchedAt     time.Time
+	Duration      time.Duration
+	ResponseSize  int
+	UnknownFields []UnknownField
 }
 
 type GetAppliancesResult struct {
-	StatusCode int
-	Meta       *Meta
-	Appliances []*Appliance
-	IsCache    bool
+	StatusCode    int
+	Meta          *Meta
+	Appliances    []*Appliance
+	IsCache       bool
+	FetchedAt     time.Time
+	Duration      time.Duration
+	ResponseSize  int
+	UnknownFields []UnknownField
 }
 
 type Appliance struct {
-	ID         string      `json:"id"`
-	Device     *Device     `json:"device"`
-	Model      *Model      `json:"model"`
-	Type       string      `json:"type"`
-	Nickname   string      `json:"nickname"`
-	Image      string      `json:"image"`
-	SmartMeter *SmartMeter `json:"smart_meter"`
+	ID         string          `json:"id"`
+	Device     *Device         `json:"device"`
+	Model      *Model          `json:"model"`
+	Type       string          `json:"type"`
+	Nickname   string          `json:"nickname"`
+	Image      string          `json:"image"`
+	Settings   *AirconSettings `json:"settings"`
+	Aircon     *Aircon         `json:"aircon"`
+	Signals    []*Signal       `json:"signals"`
+	TV         *TV             `json:"tv"`
+	Light      *Light          `json:"light"`
+	SmartMeter *SmartMeter     `json:"smart_meter"`
 }
 
 type Model struct {
@@ -71,6 +92,71 @@ type Model struct {
 	Image        string `json:"image"`
 }
 
+// Signal is an infrared signal learned by a remo device
+type Signal struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Image string `json:"image"`
+}
+
+// Aircon describes the settings an air conditioner supports
+type Aircon struct {
+	Range    *AirconRange `json:"range"`
+	TempUnit string       `json:"tempUnit"`
+}
+
+// AirconRange holds the supported values of each operation mode keyed by the mode (e.g. cool)
+type AirconRange struct {
+	Modes        map[string]*AirconRangeMode `json:"modes"`
+	FixedButtons []string                    `json:"fixedButtons"`
+}
+
+type AirconRangeMode struct {
+	Temp []string `json:"temp"`
+	Vol  []string `json:"vol"`
+	Dir  []string `json:"dir"`
+	DirH []string `json:"dirh"`
+}
+
+// AirconSettings is the last state sent to an air conditioner
+type AirconSettings struct {
+	Temp      string    `json:"temp"`
+	TempUnit  string    `json:"temp_unit"`
+	Mode      string    `json:"mode"`
+	Vol       string    `json:"vol"`
+	Dir       string    `json:"dir"`
+	DirH      string    `json:"dirh"`
+	Button    string    `json:"button"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Button is a button of the remote controller of a light or a TV
+type Button struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	Label string `json:"label"`
+}
+
+type Light struct {
+	Buttons []*Button   `json:"buttons"`
+	State   *LightState `json:"state"`
+}
+
+type LightState struct {
+	Brightness string `json:"brightness"`
+	Power      string `json:"power"`
+	LastButton string `json:"last_button"`
+}
+
+type TV struct {
+	Buttons []*Button `json:"buttons"`
+	State   *TVState  `json:"state"`
+}
+
+type TVState struct {
+	Input string `json:"input"`
+}
+
 type SmartMeter struct {
 	EchonetliteProperties []*EchonetliteProperty `json:"echonetlite_properties"`
 }
@@ -81,3 +167,22 @@ type EchonetliteProperty struct {
 	Val       string    `json:"val"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
+
+// UnknownField is a JSON key in a Remo API response which isn't decoded into any type
+type UnknownField struct {
+	Object string
+	Field  string
+}
+
+// APIError is returned when the Remo API responds with a non 200 status code.
+// Code and Message are decoded from the error body returned by the API.
+type APIError struct {
+	StatusCode int    `json:"-"`
+	Code       int    `json:"code"`
+	Message    string `json:"message"`
+	Meta       *Meta  `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("remo api responded with status %d (code: %d, message: %q)", e.StatusCode, e.Code, e.Message)
+}
@@ -0,0 +1,62 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/kenfdev/remo-exporter/listenaddr"
+)
+
+// Listen listens on a TCP address like :9352, 127.0.0.1:9352 or [::1]:9352,
+// or on a unix socket like unix:/run/remo-exporter.sock.
+// A socket file left behind by a process which didn't shut down is replaced.
+func Listen(address string) (net.Listener, error) {
+	network, addr, err := listenaddr.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		if err := removeStaleSocket(addr); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen(network, addr)
+}
+
+// ListenAll listens on all addresses. The listeners already opened are closed if one fails.
+func ListenAll(addresses []string) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(addresses))
+	for _, address := range addresses {
+		l, err := Listen(address)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// removeStaleSocket removes the socket at path if nothing accepts connections on it
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and isn't a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
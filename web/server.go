@@ -0,0 +1,173 @@
+// Package web serves the HTTP endpoints of the exporter with the TLS and basic auth settings of a
+// web configuration file of the Prometheus exporter-toolkit:
+//
+//	tls_server_config:
+//	  cert_file: server.crt
+//	  key_file: server.key
+//	basic_auth_users:
+//	  alice: $2y$10$...
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	toolkitweb "github.com/prometheus/exporter-toolkit/web"
+
+	"github.com/kenfdev/remo-exporter/log"
+)
+
+// ListenAndServe listens on server.Addr, which may be a unix:<path> socket, and serves server like Serve
+func ListenAndServe(server *http.Server, configPath string, logger log.Logger) error {
+	addr := server.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	l, err := Listen(addr)
+	if err != nil {
+		return err
+	}
+	return Serve(l, server, configPath, logger)
+}
+
+// Serve serves server on l with the settings of the web configuration file at configPath.
+// Plain HTTP without authentication is served if configPath is empty.
+// The exporter-toolkit reads the file for every request and TLS connection, so users, headers
+// and certificates are updated without a restart. Whether TLS is enabled is decided when Serve is called.
+func Serve(l net.Listener, server *http.Server, configPath string, logger log.Logger) error {
+	return ServeListeners([]net.Listener{l}, server, configPath, logger)
+}
+
+// ServeListeners serves server on all listeners like Serve. It returns the first error
+// of a listener, e.g. http.ErrServerClosed after server.Shutdown was called.
+func ServeListeners(listeners []net.Listener, server *http.Server, configPath string, logger log.Logger) error {
+	if len(listeners) == 0 {
+		return errors.New("no listener to serve")
+	}
+	if err := toolkitweb.Validate(configPath); err != nil {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return fmt.Errorf("invalid web config %s: %w", configPath, err)
+	}
+
+	// the toolkit sets up the handler of server for every listener it serves,
+	// so the listeners are served as one
+	l := listeners[0]
+	if len(listeners) > 1 {
+		for _, l := range listeners[1:] {
+			logger.Infof("Listening on %s", l.Addr())
+		}
+		l = newMultiListener(listeners)
+	}
+	return toolkitweb.Serve(l, server, &toolkitweb.FlagConfig{WebConfigFile: &configPath}, &kitLogger{logger: logger})
+}
+
+// kitLogger writes the key value pairs logged by the exporter-toolkit to a log.Logger
+type kitLogger struct {
+	logger log.Logger
+}
+
+func (k *kitLogger) Log(keyvals ...interface{}) error {
+	var level, msg string
+	var fields []string
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		key, value := fmt.Sprint(keyvals[i]), fmt.Sprint(keyvals[i+1])
+		switch key {
+		case "level":
+			level = value
+		case "msg":
+			msg = value
+		default:
+			if key == "err" && level == "" {
+				level = "error"
+			}
+			fields = append(fields, key+"="+value)
+		}
+	}
+	line := strings.TrimSpace(msg + " " + strings.Join(fields, " "))
+	switch level {
+	case "error", "warn":
+		k.logger.Errorf("%s", line)
+	case "debug":
+		k.logger.Debugf("%s", line)
+	default:
+		k.logger.Infof("%s", line)
+	}
+	return nil
+}
+
+// multiListener accepts the connections of several listeners
+type multiListener struct {
+	listeners []net.Listener
+	conns     chan acceptResult
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newMultiListener(listeners []net.Listener) *multiListener {
+	m := &multiListener{
+		listeners: listeners,
+		conns:     make(chan acceptResult),
+		done:      make(chan struct{}),
+	}
+	for _, l := range listeners {
+		go m.accept(l)
+	}
+	return m
+}
+
+// accept passes the connections and errors of l to Accept until the listener is closed
+func (m *multiListener) accept(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		select {
+		case m.conns <- acceptResult{conn, err}:
+		case <-m.done:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if err != nil && errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-m.conns:
+		return r.conn, r.err
+	case <-m.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes all listeners
+func (m *multiListener) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.done)
+		for _, l := range m.listeners {
+			if cerr := l.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
+
+// Addr returns the address of the first listener
+func (m *multiListener) Addr() net.Addr {
+	return m.listeners[0].Addr()
+}
@@ -0,0 +1,312 @@
+package web_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/kenfdev/remo-exporter/log"
+	"github.com/kenfdev/remo-exporter/web"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hash(t *testing.T, password string) string {
+	t.Helper()
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(h)
+}
+
+// serve serves a handler responding OK with the web config at path and returns its address
+func serve(t *testing.T, path string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})}
+	t.Cleanup(func() { server.Close() })
+	go web.Serve(l, server, path, log.Default())
+	return l.Addr().String()
+}
+
+func TestServeRejectsInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"unknown field", "tls_server_config:\n  certificate: x\n", "field certificate not found"},
+		{"missing key file", "tls_server_config:\n  cert_file: server.crt\n", "missing one of key or key_file"},
+		{"missing cert file", "tls_server_config:\n  cert_file: missing.crt\n  key_file: missing.key\n", "failed to read cert_file"},
+		{"invalid version", "tls_server_config:\n  min_version: TLS9\n", "unknown TLS version"},
+		{"invalid cipher suite", "tls_server_config:\n  cipher_suites: [NOPE]\n", "unknown cipher"},
+		{"forbidden header", "http_server_config:\n  headers:\n    Content-Type: text/plain\n", "can not be configured"},
+		{"invalid hash", "basic_auth_users:\n  alice: secret\n", "bcrypt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "web.yml")
+			writeFile(t, path, tt.config)
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = web.Serve(l, &http.Server{}, path, log.Default())
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("unexpected error want=%q got=%v", tt.err, err)
+			}
+			if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+				t.Fatalf("expected the listener to be closed got=%v", err)
+			}
+		})
+	}
+}
+
+func TestBasicAuthIsReloaded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "web.yml")
+	writeFile(t, path, "basic_auth_users:\n  alice: "+hash(t, "alice_password")+"\nhttp_server_config:\n  headers:\n    X-Frame-Options: deny\n")
+	addr := serve(t, path)
+
+	get := func(user string, password string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("GET", "http://"+addr, nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if want, got := http.StatusUnauthorized, get("", "").StatusCode; want != got {
+		t.Fatalf("unexpected status code without credentials want=%d got=%d", want, got)
+	}
+	if want, got := http.StatusUnauthorized, get("alice", "wrong").StatusCode; want != got {
+		t.Fatalf("unexpected status code with a wrong password want=%d got=%d", want, got)
+	}
+	resp := get("alice", "alice_password")
+	if want, got := http.StatusOK, resp.StatusCode; want != got {
+		t.Fatalf("unexpected status code want=%d got=%d", want, got)
+	}
+	if want, got := "deny", resp.Header.Get("X-Frame-Options"); want != got {
+		t.Fatalf("unexpected header want=%s got=%s", want, got)
+	}
+
+	writeFile(t, path, "basic_auth_users:\n  bob: "+hash(t, "bob_password")+"\n")
+	if want, got := http.StatusUnauthorized, get("alice", "alice_password").StatusCode; want != got {
+		t.Fatalf("unexpected status code of a removed user want=%d got=%d", want, got)
+	}
+	if want, got := http.StatusOK, get("bob", "bob_password").StatusCode; want != got {
+		t.Fatalf("unexpected status code of an added user want=%d got=%d", want, got)
+	}
+
+	// an invalid file fails the requests until it is fixed
+	writeFile(t, path, "basic_auth_users: [bob]\n")
+	if want, got := http.StatusInternalServerError, get("bob", "bob_password").StatusCode; want != got {
+		t.Fatalf("unexpected status code after an invalid change want=%d got=%d", want, got)
+	}
+}
+
+// certificate is a certificate and its key in PEM
+type certificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM string
+	keyPEM  string
+}
+
+// newCertificate returns a certificate signed by parent or a self-signed CA if parent is nil
+func newCertificate(t *testing.T, name string, parent *certificate, usage x509.ExtKeyUsage) *certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.ExtKeyUsage = nil
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &certificate{
+		cert:    cert,
+		key:     key,
+		certPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
+func TestTLSWithClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCertificate(t, "ca", nil, x509.ExtKeyUsageAny)
+	server := newCertificate(t, "localhost", ca, x509.ExtKeyUsageServerAuth)
+	allowed := newCertificate(t, "prometheus", ca, x509.ExtKeyUsageClientAuth)
+	other := newCertificate(t, "other", ca, x509.ExtKeyUsageClientAuth)
+	writeFile(t, filepath.Join(dir, "ca.crt"), ca.certPEM)
+	writeFile(t, filepath.Join(dir, "server.crt"), server.certPEM)
+	writeFile(t, filepath.Join(dir, "server.key"), server.keyPEM)
+	path := filepath.Join(dir, "web.yml")
+	// relative paths are relative to the web config file
+	writeFile(t, path, `tls_server_config:
+  cert_file: server.crt
+  key_file: server.key
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: ca.crt
+  client_allowed_sans: [prometheus]
+`)
+	addr := serve(t, path)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	get := func(client *certificate) error {
+		t.Helper()
+		tlsConfig := &tls.Config{RootCAs: pool}
+		if client != nil {
+			pair, err := tls.X509KeyPair([]byte(client.certPEM), []byte(client.keyPEM))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := c.Get("https://" + addr)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if err := get(allowed); err != nil {
+		t.Fatalf("unexpected error with an allowed client certificate: %v", err)
+	}
+	if err := get(nil); err == nil {
+		t.Fatal("expected an error without a client certificate")
+	}
+	if err := get(other); err == nil {
+		t.Fatal("expected an error with a client certificate whose SAN isn't allowed")
+	}
+}
+
+func TestListenReplacesStaleUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "remo.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// keep the socket file behind like a process which was killed
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := web.Listen("unix:" + path)
+	if err != nil {
+		t.Fatalf("unexpected error listening on a stale socket: %v", err)
+	}
+	defer l.Close()
+
+	if _, err := web.Listen("unix:" + path); err == nil {
+		t.Fatal("expected an error listening on a socket in use")
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	writeFile(t, file, "")
+	if _, err := web.Listen("unix:" + file); err == nil {
+		t.Fatal("expected an error listening on a file which isn't a socket")
+	}
+}
+
+func TestServeListenersUntilShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "remo.sock")
+	listeners, err := web.ListenAll([]string{"127.0.0.1:0", "unix:" + path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})}
+	errc := make(chan error, 1)
+	go func() { errc <- web.ServeListeners(listeners, server, "", log.Default()) }()
+
+	clients := map[string]*http.Client{
+		"tcp": http.DefaultClient,
+		"unix": {Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		}}},
+	}
+	urls := map[string]string{"tcp": "http://" + listeners[0].Addr().String(), "unix": "http://unix"}
+	for name, c := range clients {
+		resp, err := c.Get(urls[name])
+		if err != nil {
+			t.Fatalf("unexpected error over %s: %v", name, err)
+		}
+		resp.Body.Close()
+		if want, got := http.StatusOK, resp.StatusCode; want != got {
+			t.Fatalf("unexpected status code over %s want=%d got=%d", name, want, got)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("unexpected error after shutdown: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the socket to be removed: %v", err)
+	}
+}